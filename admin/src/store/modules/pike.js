@@ -115,9 +115,20 @@ const mutations = {
         if (_.includes(item.availableBackends, backend)) {
           status = 'healthy';
         }
+        const health = _.get(item.healthStatus, backend);
+        let checkedAt = '';
+        if (health) {
+          checkedAt = dayjs(health.checkedAt).format('YYYY-MM-DD HH:mm:ss');
+        }
         backends.push({
           backend,
           status,
+          // 通过admin设置的状态（draining disabled）
+          state: _.get(item.backendStates, backend, ''),
+          inflight: _.get(item.inflights, backend, 0),
+          checkedAt,
+          checkSuccess: health ? health.success : false,
+          checkError: health ? health.error : '',
         });
       });
       item.backends = backends;
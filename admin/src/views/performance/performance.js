@@ -60,6 +60,18 @@ const performanceItems = {
     name: 'the size of db',
     desc: 'the data size of db',
   },
+  evicted: {
+    name: 'evicted count',
+    desc: 'the count of cache evicted by size limit',
+  },
+  upgrading: {
+    name: 'upgrading',
+    desc: 'the count of upgrade connection(websocket) in proxy',
+  },
+  upgradeCount: {
+    name: 'upgrade count',
+    desc: 'the total count of upgrade connection(websocket)',
+  },
 };
 
 export default {
@@ -5,7 +5,6 @@ import (
 	"encoding/binary"
 	"errors"
 	"net/http"
-	"os"
 	"strings"
 	"sync"
 	"time"
@@ -13,8 +12,6 @@ import (
 
 	jsoniter "github.com/json-iterator/go"
 	"github.com/vicanso/pike/util"
-
-	"github.com/akrylysov/pogreb"
 )
 
 var json = jsoniter.ConfigCompatibleWithStandardLibrary
@@ -22,6 +19,27 @@ var json = jsoniter.ConfigCompatibleWithStandardLibrary
 var (
 	// ErrBodyCotentNotFound 无数据
 	ErrBodyCotentNotFound = errors.New("content not found")
+	// ErrInvalidRecord 缓存数据格式不正确
+	ErrInvalidRecord = errors.New("invalid cache record")
+)
+
+const (
+	// recordMagic 带版本号的缓存数据的标记，旧格式的数据首4字节为createdAt，
+	// 而此标记（小端序）对应的时间为2006-11-03，不会出现在旧格式的数据中
+	recordMagic = "PIKE"
+	// recordVersion 当前缓存数据的格式版本
+	// 1: ttl为uint32
+	// 2: 增加stale-while-revalidate与stale-if-error
+	// 3: 增加Vary字段与请求的基础标记长度（重启后恢复Vary）
+	recordVersion = 3
+	// legacyHeaderLength 旧格式（无版本号，ttl为uint16）的头部长度
+	legacyHeaderLength = 24
+	// recordV1HeaderLength 版本1的头部长度
+	recordV1HeaderLength = 31
+	// recordV2HeaderLength 版本2的头部长度
+	recordV2HeaderLength = 39
+	// recordHeaderLength 当前格式的头部长度
+	recordHeaderLength = 47
 )
 
 const (
@@ -35,6 +53,15 @@ const (
 	HitForPass
 	// Cacheable request status: cacheable
 	Cacheable
+	// Stale request status: stale（使用过期的缓存数据）
+	Stale
+)
+
+const (
+	// LRU 淘汰最久未被访问的缓存
+	LRU = "lru"
+	// LFU 淘汰访问次数最少的缓存
+	LFU = "lfu"
 )
 
 const (
@@ -54,15 +81,40 @@ var StatusDescArr = []string{
 	"waiting",
 	"hitForPass",
 	"cacheable",
+	"stale",
 }
 
 type (
 
 	// Client 缓存
 	Client struct {
-		Path  string
-		db    *pogreb.DB
-		rsMap map[string]*RequestStatus
+		// Path 缓存数据的保存路径（disk时使用）
+		Path string
+		// Driver 缓存数据的存储类型，默认为disk
+		Driver string
+		// MaxSize 缓存数据的最大字节数，0表示不限制
+		MaxSize int64
+		// MaxEntries 缓存数据的最大数量，0表示不限制
+		MaxEntries int
+		// EvictionPolicy 超出限制时的淘汰策略（lru lfu），默认为lru
+		EvictionPolicy string
+		// TagHeader 缓存标签的响应头（如Surrogate-Key），用于按标签删除缓存
+		TagHeader string
+		store     Storage
+		rsMap     map[string]*RequestStatus
+		// 各请求标记对应响应的Vary字段
+		varyMap map[string][]string
+		// 标签对应的缓存key，以及缓存key对应的标签
+		tagMap     map[string]map[string]bool
+		keyTagsMap map[string][]string
+		// 已保存的缓存数据大小与数量
+		size  int64
+		count int
+		// 被淘汰的缓存数量
+		evicted uint64
+		// 缓存数据的淘汰顺序，访问缓存时只有读锁，因此使用单独的锁
+		evictLock sync.Mutex
+		evictions evictionList
 		sync.RWMutex
 	}
 	// Response 响应数据
@@ -71,8 +123,12 @@ type (
 		CreatedAt uint32 `json:"createdAt"`
 		// HTTP状态码
 		StatusCode uint16 `json:"statusCode"`
-		// 缓存有效时间(最大65535)
-		TTL uint16 `json:"ttl"`
+		// 缓存有效时间
+		TTL uint32 `json:"ttl"`
+		// 过期后可直接返回并在后台更新的时间（stale-while-revalidate）
+		StaleWhileRevalidate uint32 `json:"staleWhileRevalidate"`
+		// 过期后出错时可返回的时间（stale-if-error）
+		StaleIfError uint32 `json:"staleIfError"`
 		// HTTP响应头
 		Header http.Header `json:"header"`
 		// HTTP响应数据
@@ -85,15 +141,26 @@ type (
 		CompressLevel int `json:"compressLevel"`
 		// 最小压缩数据
 		CompressMinLength int `json:"compressMinLength"`
+		// BaseKey 响应有Vary时请求的基础标记（保存的标记为其对应版本的标记）
+		BaseKey []byte `json:"-"`
+		// Vary 生成对应版本标记的Vary字段，与BaseKey一起保存，重启后用于恢复
+		Vary []string `json:"vary,omitempty"`
 	}
 	// RequestStatus 获取请求状态
 	RequestStatus struct {
 		createdAt uint32
-		ttl       uint16
+		ttl       uint32
 		// 请求状态 fetching hitForPass 等
 		status int
 		// 如果此请求为fetching，则此时相同的请求会写入一个chan
 		waitingChans []chan int
+		// 保存的缓存数据大小
+		size int
+		// 过期后可使用过期数据的时间
+		staleWhileRevalidate uint32
+		staleIfError         uint32
+		// 重新获取数据时，原来已过期的缓存状态
+		stale *RequestStatus
 	}
 	// Stats 各状态数量统计
 	Stats struct {
@@ -102,13 +169,31 @@ type (
 		HitForPass int `json:"hitForPass"`
 		Cacheable  int `json:"cacheable"`
 		FileSize   int `json:"fileSize"`
+		// Evicted 因超出限制而被淘汰的缓存数量
+		Evicted uint64 `json:"evicted"`
 	}
 	// CachedResponse  缓存的请求
 	CachedResponse struct {
 		Key       string `json:"key"`
-		TTL       uint16 `json:"ttl"`
+		TTL       uint32 `json:"ttl"`
 		CreatedAt uint32 `json:"createdAt"`
 	}
+	// recordHeader 缓存数据的头部信息
+	recordHeader struct {
+		createdAt            uint32
+		statusCode           uint16
+		ttl                  uint32
+		staleWhileRevalidate uint32
+		staleIfError         uint32
+		baseKeyLength        uint32
+		varyLength           uint32
+		headerLength         uint32
+		bodyLength           uint32
+		gzipLength           uint32
+		brLength             uint32
+		// vary 保存时的Vary字段（以,分隔）
+		vary []byte
+	}
 	// FetchingResponse fetching中的请求
 	FetchingResponse struct {
 		Key       string `json:"key"`
@@ -148,12 +233,33 @@ func bytesToUint32(buf []byte) uint32 {
 // 判断是否已过期 内嵌性能更高
 func isExpired(rs *RequestStatus) bool {
 	now := uint32(time.Now().Unix())
-	if rs.ttl != 0 && now-rs.createdAt > uint32(rs.ttl) {
+	if rs.ttl != 0 && now-rs.createdAt > rs.ttl {
 		return true
 	}
 	return false
 }
 
+// 判断是否在过期后的可用时间内（stale-while-revalidate stale-if-error）
+func isWithinStale(rs *RequestStatus, stale uint32) bool {
+	if rs == nil || rs.status != Cacheable || stale == 0 {
+		return false
+	}
+	now := uint32(time.Now().Unix())
+	// 使用uint64避免相加溢出
+	return uint64(now-rs.createdAt) <= uint64(rs.ttl)+uint64(stale)
+}
+
+// 判断过期或者fetching中的请求是否可以直接使用过期的缓存数据（stale-while-revalidate）
+func canServeStale(rs *RequestStatus) bool {
+	if rs.status == Fetching {
+		rs = rs.stale
+	}
+	if rs == nil {
+		return false
+	}
+	return isWithinStale(rs, rs.staleWhileRevalidate)
+}
+
 func (r *Response) getRawBody() ([]byte, error) {
 	if len(r.Body) != 0 {
 		return r.Body, nil
@@ -239,18 +345,69 @@ func (r *Response) GetBody(acceptEncoding string) (body []byte, encoding string)
 	return
 }
 
-// Init 初始化缓存
+// Init 初始化缓存，并从存储中恢复未过期的缓存
 func (c *Client) Init() error {
-	os.Remove(c.Path + ".lock")
-	db, err := pogreb.Open(c.Path, nil)
-	c.db = db
+	store, err := NewStorage(c.Driver, c.Path)
+	c.store = store
 	c.rsMap = make(map[string]*RequestStatus)
+	c.varyMap = make(map[string][]string)
+	c.tagMap = make(map[string]map[string]bool)
+	c.keyTagsMap = make(map[string][]string)
+	c.evictions = newEvictionList(c.EvictionPolicy)
+	if err != nil {
+		return err
+	}
+	return c.restore()
+}
+
+// restore 从存储中恢复可缓存的请求状态，并删除已过期的数据
+func (c *Client) restore() error {
+	c.Lock()
+	defer c.Unlock()
+	expiredKeys := make([][]byte, 0)
+	err := c.store.Iterate(func(key, value []byte) bool {
+		h, offset, err := decodeRecordHeader(value)
+		// 数据不完整或者没有有效期的，都删除
+		if err != nil || h.ttl == 0 {
+			expiredKeys = append(expiredKeys, append([]byte(nil), key...))
+			return true
+		}
+		rs := &RequestStatus{
+			createdAt:            h.createdAt,
+			ttl:                  h.ttl,
+			status:               Cacheable,
+			staleWhileRevalidate: h.staleWhileRevalidate,
+			staleIfError:         h.staleIfError,
+		}
+		if isExpired(rs) && !canServeStale(rs) && !isWithinStale(rs, rs.staleIfError) {
+			expiredKeys = append(expiredKeys, append([]byte(nil), key...))
+			return true
+		}
+		k := string(key)
+		c.rsMap[k] = rs
+		c.setStoredSize(k, rs, len(key)+len(value))
+		// Vary对应版本的缓存，恢复其基础标记的Vary字段
+		if vary := h.getVary(key); len(vary) != 0 {
+			c.varyMap[string(key[:h.baseKeyLength])] = vary
+		}
+		if len(c.TagHeader) != 0 {
+			header := make(http.Header)
+			if json.Unmarshal(value[offset:offset+h.headerLength], &header) == nil {
+				c.indexTags(k, getTags(header, c.TagHeader))
+			}
+		}
+		return true
+	})
+	for _, key := range expiredKeys {
+		c.store.Delete(key)
+	}
+	c.evict()
 	return err
 }
 
 // Close 关闭缓存
 func (c *Client) Close() error {
-	return c.db.Close()
+	return c.store.Close()
 }
 
 // SaveResponse 保存response
@@ -264,82 +421,285 @@ func (c *Client) SaveResponse(key []byte, resp *Response) error {
 	if err != nil {
 		return err
 	}
+	// 保存的标记为Vary对应版本的标记时，记录基础标记的长度与Vary字段
+	var baseKeyLength int
+	var vary []byte
+	if len(resp.Vary) != 0 && len(resp.BaseKey) < len(key) && bytes.HasPrefix(key, resp.BaseKey) {
+		baseKeyLength = len(resp.BaseKey)
+		vary = []byte(strings.Join(resp.Vary, ","))
+	}
 	// 将要保存的数据转换为bytes
 	body := resp.Body
 	gzipBody := resp.GzipBody
 	brBody := resp.BrBody
 	s := [][]byte{
+		[]byte(recordMagic),
+		[]byte{recordVersion},
 		uint32ToBytes(createdAt),
 		uint16ToBytes(resp.StatusCode),
-		uint16ToBytes(resp.TTL),
+		uint32ToBytes(resp.TTL),
+		uint32ToBytes(resp.StaleWhileRevalidate),
+		uint32ToBytes(resp.StaleIfError),
+		uint32ToBytes(uint32(baseKeyLength)),
+		uint32ToBytes(uint32(len(vary))),
 		uint32ToBytes(uint32(len(header))),
 		uint32ToBytes(uint32(len(body))),
 		uint32ToBytes(uint32(len(gzipBody))),
 		uint32ToBytes(uint32(len(brBody))),
+		vary,
 		header,
 		body,
 		gzipBody,
 		brBody,
 	}
 	data := bytes.Join(s, nil)
-	return c.db.Put(key, data)
+	err = c.store.Put(key, data)
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	k := byteSliceToString(key)
+	rs := c.rsMap[k]
+	if rs == nil {
+		return nil
+	}
+	c.indexTags(string(key), getTags(resp.Header, c.TagHeader))
+	rs.staleWhileRevalidate = resp.StaleWhileRevalidate
+	rs.staleIfError = resp.StaleIfError
+	c.setStoredSize(string(key), rs, len(key)+len(data))
+	c.evict()
+	return nil
+}
+
+// setStoredSize 设置缓存数据的大小，并更新总的大小、数量与淘汰顺序（需要在lock中调用）
+func (c *Client) setStoredSize(k string, rs *RequestStatus, size int) {
+	if rs.size != 0 {
+		c.size -= int64(rs.size)
+		c.count--
+	}
+	if size != 0 {
+		c.size += int64(size)
+		c.count++
+	}
+	rs.size = size
+	c.evictLock.Lock()
+	if size != 0 {
+		c.evictions.add(k)
+	} else {
+		c.evictions.remove(k)
+	}
+	c.evictLock.Unlock()
+}
+
+// hit 更新缓存被访问的记录
+func (c *Client) hit(k string) {
+	c.evictLock.Lock()
+	c.evictions.hit(k)
+	c.evictLock.Unlock()
+}
+
+// isOverLimit 判断缓存数据是否超出限制（需要在lock中调用）
+func (c *Client) isOverLimit() bool {
+	if c.MaxSize > 0 && c.size > c.MaxSize {
+		return true
+	}
+	if c.MaxEntries > 0 && c.count > c.MaxEntries {
+		return true
+	}
+	return false
+}
+
+// evict 如果缓存数据超出限制，根据淘汰策略删除缓存（需要在lock中调用）
+func (c *Client) evict() {
+	if !c.isOverLimit() {
+		return
+	}
+	// 按淘汰顺序获取需要删除的缓存，删除后不再超出限制则停止
+	keys := make([]string, 0)
+	size := c.size
+	count := c.count
+	c.evictLock.Lock()
+	c.evictions.each(func(k string) bool {
+		rs := c.rsMap[k]
+		if rs != nil {
+			// fetching中的数据正在保存，不淘汰
+			if rs.status == Fetching {
+				return true
+			}
+			size -= int64(rs.size)
+			count--
+		}
+		keys = append(keys, k)
+		return (c.MaxSize > 0 && size > c.MaxSize) || (c.MaxEntries > 0 && count > c.MaxEntries)
+	})
+	c.evictLock.Unlock()
+	for _, k := range keys {
+		c.store.Delete([]byte(k))
+		c.unindexTags(k)
+		rs := c.rsMap[k]
+		if rs == nil {
+			c.evictLock.Lock()
+			c.evictions.remove(k)
+			c.evictLock.Unlock()
+			continue
+		}
+		c.setStoredSize(k, rs, 0)
+		// hit for pass的状态保留，只删除已无用的数据
+		if rs.status == Cacheable {
+			delete(c.rsMap, k)
+		}
+		c.evicted++
+	}
 }
 
 // GetResponse 从缓存中获取Response
 func (c *Client) GetResponse(key []byte) (resp *Response, err error) {
-	data, err := c.db.Get(key)
+	data, err := c.store.Get(key)
 	if err != nil {
 		return
 	}
 	if data == nil || len(data) == 0 {
 		return
 	}
-	resp = &Response{}
-	resp.CreatedAt = bytesToUint32(data[0:4])
-	resp.StatusCode = bytesToUint16(data[4:6])
-	resp.TTL = bytesToUint16(data[6:8])
-	headerLength := bytesToUint32(data[8:12])
-	bodyLength := bytesToUint32(data[12:16])
-	gzipLength := bytesToUint32(data[16:20])
-	brLength := bytesToUint32(data[20:24])
-	var offset uint32 = 24
+	h, offset, err := decodeRecordHeader(data)
+	if err != nil {
+		return
+	}
 	header := make(http.Header)
-	err = json.Unmarshal(data[offset:offset+headerLength], &header)
-	offset += headerLength
+	err = json.Unmarshal(data[offset:offset+h.headerLength], &header)
+	offset += h.headerLength
 	if err != nil {
 		return
 	}
-	resp.Header = header
+	resp = &Response{
+		CreatedAt:            h.createdAt,
+		StatusCode:           h.statusCode,
+		TTL:                  h.ttl,
+		StaleWhileRevalidate: h.staleWhileRevalidate,
+		StaleIfError:         h.staleIfError,
+		Header:               header,
+	}
+	if vary := h.getVary(key); len(vary) != 0 {
+		resp.BaseKey = append([]byte(nil), key[:h.baseKeyLength]...)
+		resp.Vary = vary
+	}
 
-	resp.Body = data[offset : offset+bodyLength]
-	offset += bodyLength
+	resp.Body = data[offset : offset+h.bodyLength]
+	offset += h.bodyLength
 
-	resp.GzipBody = data[offset : offset+gzipLength]
-	offset += gzipLength
+	resp.GzipBody = data[offset : offset+h.gzipLength]
+	offset += h.gzipLength
 
-	resp.BrBody = data[offset : offset+brLength]
+	resp.BrBody = data[offset : offset+h.brLength]
 
 	return
 }
 
+// decodeRecordHeader 解析缓存数据的头部，兼容旧格式（无版本号，ttl为uint16）的数据
+func decodeRecordHeader(data []byte) (h *recordHeader, offset uint32, err error) {
+	h = &recordHeader{}
+	size := uint32(len(data))
+	if size >= recordV1HeaderLength && string(data[0:4]) == recordMagic {
+		h.createdAt = bytesToUint32(data[5:9])
+		h.statusCode = bytesToUint16(data[9:11])
+		h.ttl = bytesToUint32(data[11:15])
+		offset = 15
+		switch data[4] {
+		case 1:
+			break
+		case 2, recordVersion:
+			if size < recordV2HeaderLength {
+				err = ErrInvalidRecord
+				return
+			}
+			h.staleWhileRevalidate = bytesToUint32(data[15:19])
+			h.staleIfError = bytesToUint32(data[19:23])
+			offset = 23
+			if data[4] != recordVersion {
+				break
+			}
+			if size < recordHeaderLength {
+				err = ErrInvalidRecord
+				return
+			}
+			h.baseKeyLength = bytesToUint32(data[23:27])
+			h.varyLength = bytesToUint32(data[27:31])
+			offset = 31
+		default:
+			err = ErrInvalidRecord
+			return
+		}
+	} else if size >= legacyHeaderLength {
+		h.createdAt = bytesToUint32(data[0:4])
+		h.statusCode = bytesToUint16(data[4:6])
+		h.ttl = uint32(bytesToUint16(data[6:8]))
+		offset = 8
+	} else {
+		err = ErrInvalidRecord
+		return
+	}
+	h.headerLength = bytesToUint32(data[offset : offset+4])
+	h.bodyLength = bytesToUint32(data[offset+4 : offset+8])
+	h.gzipLength = bytesToUint32(data[offset+8 : offset+12])
+	h.brLength = bytesToUint32(data[offset+12 : offset+16])
+	offset += 16
+	// 使用uint64避免长度相加溢出
+	total := uint64(offset) + uint64(h.varyLength) + uint64(h.headerLength) + uint64(h.bodyLength) + uint64(h.gzipLength) + uint64(h.brLength)
+	if total > uint64(size) {
+		err = ErrInvalidRecord
+		return
+	}
+	h.vary = data[offset : offset+h.varyLength]
+	offset += h.varyLength
+	return
+}
+
+// getVary 获取保存时的Vary字段，基础标记的长度不正确则忽略
+func (h *recordHeader) getVary(key []byte) []string {
+	if len(h.vary) == 0 || int(h.baseKeyLength) >= len(key) {
+		return nil
+	}
+	return strings.Split(string(h.vary), ",")
+}
+
 func (c *Client) lockAndUpdateRsMap(k string) (status int, ch chan int) {
 	c.Lock()
 	defer c.Unlock()
 	rs := c.rsMap[k]
+	expired := rs != nil && isExpired(rs)
+	// 已过期或者fetching中，但是可以使用过期的缓存数据
+	if (expired || (rs != nil && rs.status == Fetching)) && canServeStale(rs) {
+		status = Stale
+		return
+	}
 	// 如果该key对应的状态为空或者已过期
-	if rs == nil || (rs.ttl != 0 && uint32(time.Now().Unix())-rs.createdAt > uint32(rs.ttl)) {
+	if rs == nil || expired {
 		status = Fetching
+		size := 0
+		var stale *RequestStatus
+		// 过期的数据还在存储中，保留其大小
+		if rs != nil {
+			size = rs.size
+			// 保留过期的缓存状态，用于出错时使用（stale-if-error）
+			if rs.status == Cacheable {
+				stale = rs
+			}
+		}
 		rs = &RequestStatus{
 			createdAt:    uint32(time.Now().Unix()),
 			ttl:          0,
 			waitingChans: make([]chan int, 0),
 			status:       Fetching,
+			size:         size,
+			stale:        stale,
 		}
 		c.rsMap[k] = rs
 	} else if rs.status == Fetching {
 		// 如果该key对应的请求正在处理中，添加chan
 		status = Waiting
-		ch = make(chan int)
+		// 使用带缓冲的channel，等待超时的请求不再读取也不会阻塞
+		ch = make(chan int, 1)
 		rs.waitingChans = append(rs.waitingChans, ch)
 	} else {
 		// hit for pass 或者 cacheable
@@ -358,24 +718,29 @@ func (c *Client) GetRequestStatus(key []byte) (status int, ch chan int) {
 		c.RUnlock()
 		return c.lockAndUpdateRsMap(k)
 	}
-	// 过期
-	if rs.ttl != 0 && uint32(time.Now().Unix())-rs.createdAt > uint32(rs.ttl) {
-		c.RUnlock()
-		return c.lockAndUpdateRsMap(k)
-	}
-	if rs.status == Fetching {
+	// 过期或者fetching中
+	if isExpired(rs) || rs.status == Fetching {
+		// 如果可以使用过期的缓存数据，则直接返回stale
+		if canServeStale(rs) {
+			c.RUnlock()
+			status = Stale
+			return
+		}
 		c.RUnlock()
 		return c.lockAndUpdateRsMap(k)
 	}
 
 	// hit for pass 或者 cacheable
 	status = rs.status
+	if status == Cacheable {
+		c.hit(k)
+	}
 	c.RUnlock()
 	return
 }
 
 // UpdateRequestStatus 更新状态，获取等待中的请求，并设置状态和有效期
-func (c *Client) UpdateRequestStatus(key []byte, status int, ttl uint16) {
+func (c *Client) UpdateRequestStatus(key []byte, status int, ttl uint32) {
 
 	c.Lock()
 	defer c.Unlock()
@@ -386,6 +751,7 @@ func (c *Client) UpdateRequestStatus(key []byte, status int, ttl uint16) {
 	}
 	rs.status = status
 	rs.ttl = ttl
+	rs.stale = nil
 	waitingChans := rs.waitingChans
 	// 对所有等待中的请求触发channel
 	for _, c := range waitingChans {
@@ -395,13 +761,82 @@ func (c *Client) UpdateRequestStatus(key []byte, status int, ttl uint16) {
 	rs.waitingChans = nil
 }
 
+// GetVary 获取请求标记对应的Vary字段
+func (c *Client) GetVary(key []byte) []string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.varyMap[byteSliceToString(key)]
+}
+
+// SetVary 设置请求标记对应的Vary字段，为空则删除
+func (c *Client) SetVary(key []byte, fields []string) {
+	c.Lock()
+	defer c.Unlock()
+	if len(fields) == 0 {
+		delete(c.varyMap, byteSliceToString(key))
+		return
+	}
+	c.varyMap[string(key)] = fields
+}
+
+// Revalidate 将可使用过期数据的缓存设置为fetching（stale-while-revalidate），
+// 返回true表示调用者需要重新获取数据，相同的请求只有一个会返回true
+func (c *Client) Revalidate(key []byte) bool {
+	c.Lock()
+	defer c.Unlock()
+	k := byteSliceToString(key)
+	rs := c.rsMap[k]
+	if rs == nil || rs.status != Cacheable || !isExpired(rs) || !canServeStale(rs) {
+		return false
+	}
+	c.rsMap[k] = &RequestStatus{
+		createdAt:    uint32(time.Now().Unix()),
+		waitingChans: make([]chan int, 0),
+		status:       Fetching,
+		size:         rs.size,
+		stale:        rs,
+	}
+	return true
+}
+
+// GetStaleIfError 获取出错时可使用的过期缓存数据（stale-if-error），
+// 如果可用则将请求状态恢复为过期的缓存，等待中的请求也使用过期的数据
+func (c *Client) GetStaleIfError(key []byte) *Response {
+	c.Lock()
+	k := byteSliceToString(key)
+	rs := c.rsMap[k]
+	stale := rs
+	if rs != nil && rs.status == Fetching {
+		stale = rs.stale
+	}
+	if stale == nil || !isWithinStale(stale, stale.staleIfError) {
+		c.Unlock()
+		return nil
+	}
+	if stale != rs {
+		stale.size = rs.size
+		c.rsMap[k] = stale
+		for _, ch := range rs.waitingChans {
+			ch <- Stale
+			close(ch)
+		}
+		rs.waitingChans = nil
+	}
+	c.Unlock()
+	resp, err := c.GetResponse(key)
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
 // HitForPass 设置为hit for pass
-func (c *Client) HitForPass(key []byte, ttl uint16) {
+func (c *Client) HitForPass(key []byte, ttl uint32) {
 	c.UpdateRequestStatus(key, HitForPass, ttl)
 }
 
 // Cacheable 设置状态为cacheable
-func (c *Client) Cacheable(key []byte, ttl uint16) {
+func (c *Client) Cacheable(key []byte, ttl uint32) {
 	c.UpdateRequestStatus(key, Cacheable, ttl)
 }
 
@@ -415,10 +850,21 @@ func (c *Client) ClearExpired(delay int) {
 		delay = 60
 	}
 	for k, v := range c.rsMap {
-		ttl := v.ttl
-		if ttl != 0 && now-v.createdAt > uint32(ttl)+uint32(delay) {
+		ttl := uint64(v.ttl)
+		// 过期后还可使用的缓存数据需要保留
+		stale := v.staleWhileRevalidate
+		if v.staleIfError > stale {
+			stale = v.staleIfError
+		}
+		if v.status == Cacheable {
+			ttl += uint64(stale)
+		}
+		// 使用uint64避免ttl较大时相加溢出
+		if v.ttl != 0 && uint64(now-v.createdAt) > ttl+uint64(delay) {
+			c.setStoredSize(k, v, 0)
+			c.unindexTags(k)
 			delete(c.rsMap, k)
-			c.db.Delete([]byte(k))
+			c.store.Delete([]byte(k))
 		}
 	}
 }
@@ -427,8 +873,13 @@ func (c *Client) ClearExpired(delay int) {
 func (c *Client) Remove(key []byte) error {
 	c.Lock()
 	defer c.Unlock()
-	delete(c.rsMap, byteSliceToString(key))
-	return c.db.Delete(key)
+	k := byteSliceToString(key)
+	if rs := c.rsMap[k]; rs != nil {
+		c.setStoredSize(k, rs, 0)
+	}
+	c.unindexTags(k)
+	delete(c.rsMap, k)
+	return c.store.Delete(key)
 }
 
 // Size 获取缓存数量
@@ -440,10 +891,11 @@ func (c *Client) Size() int {
 func (c *Client) GetStats() (stats *Stats) {
 	c.Lock()
 	defer c.Unlock()
-	fileSize, _ := c.db.FileSize()
+	fileSize, _ := c.store.Size()
 	var mb int64 = 1024 * 1024
 	stats = &Stats{
 		FileSize: int(fileSize / mb),
+		Evicted:  c.evicted,
 	}
 	for _, v := range c.rsMap {
 		switch v.status {
@@ -470,7 +922,7 @@ func (c *Client) GetCachedList() []*CachedResponse {
 	now := uint32(time.Now().Unix())
 	for key, v := range c.rsMap {
 		// 对于非可缓存的忽略
-		if v.status != Cacheable || v.createdAt+uint32(v.ttl) < now {
+		if v.status != Cacheable || now-v.createdAt > v.ttl {
 			continue
 		}
 		// 保存缓存的记录
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"net/http"
+	"strconv"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -12,7 +13,8 @@ import (
 )
 
 const (
-	dbPath = "/tmp/test.cache"
+	// 缓存初始化时会恢复已保存的数据，因此不与其它package的测试共用db文件
+	dbPath = "/tmp/test-cache-package.cache"
 )
 
 func TestCacheClient(t *testing.T) {
@@ -50,7 +52,7 @@ func TestTypeConvert(t *testing.T) {
 
 func TestIsExpired(t *testing.T) {
 	now := uint32(time.Now().Unix())
-	ttl := uint16(30)
+	ttl := uint32(30)
 	rs := &RequestStatus{
 		ttl:       ttl,
 		createdAt: now,
@@ -58,7 +60,7 @@ func TestIsExpired(t *testing.T) {
 	if isExpired(rs) {
 		t.Fatalf("is expired function fail, it should not expired")
 	}
-	rs.createdAt = now - uint32(ttl) - 1
+	rs.createdAt = now - ttl - 1
 	if !isExpired(rs) {
 		t.Fatalf("is expired function fail, it should expired")
 	}
@@ -74,6 +76,8 @@ func TestResponse(t *testing.T) {
 	}
 	defer c.Close()
 	key := []byte("pike.aslant.site /users/me")
+	// 删除保存的数据，避免后续的测试初始化时恢复此缓存
+	defer c.Remove(key)
 	header := make(http.Header)
 	header["token"] = []string{
 		"A",
@@ -235,6 +239,101 @@ func TestResponse(t *testing.T) {
 	})
 }
 
+func TestRecordVersion(t *testing.T) {
+	c := Client{
+		Driver: MemoryStorage,
+	}
+	err := c.Init()
+	if err != nil {
+		t.Fatalf("cache init fail, %v", err)
+	}
+	defer c.Close()
+	now := uint32(time.Now().Unix())
+	t.Run("long ttl", func(t *testing.T) {
+		key := []byte("long ttl")
+		ttl := uint32(7 * 24 * 3600)
+		c.SaveResponse(key, &Response{
+			CreatedAt:  now,
+			StatusCode: 200,
+			TTL:        ttl,
+			Body:       []byte("week"),
+		})
+		resp, err := c.GetResponse(key)
+		if err != nil || resp.TTL != ttl || string(resp.Body) != "week" {
+			t.Fatalf("get long ttl response fail, %v", err)
+		}
+	})
+
+	t.Run("legacy record", func(t *testing.T) {
+		key := []byte("legacy record")
+		header := []byte(`{"X-Token":["A"]}`)
+		body := []byte("legacy body")
+		data := bytes.Join([][]byte{
+			uint32ToBytes(now),
+			uint16ToBytes(200),
+			uint16ToBytes(600),
+			uint32ToBytes(uint32(len(header))),
+			uint32ToBytes(uint32(len(body))),
+			uint32ToBytes(0),
+			uint32ToBytes(0),
+			header,
+			body,
+		}, nil)
+		c.store.Put(key, data)
+		resp, err := c.GetResponse(key)
+		if err != nil {
+			t.Fatalf("get legacy response fail, %v", err)
+		}
+		if resp.CreatedAt != now || resp.StatusCode != 200 || resp.TTL != 600 {
+			t.Fatalf("the legacy record header is wrong")
+		}
+		if resp.Header.Get("X-Token") != "A" || !bytes.Equal(resp.Body, body) {
+			t.Fatalf("the legacy record data is wrong")
+		}
+	})
+
+	t.Run("version 2 record", func(t *testing.T) {
+		key := []byte("version 2 record")
+		header := []byte(`{"X-Token":["A"]}`)
+		body := []byte("version 2 body")
+		data := bytes.Join([][]byte{
+			[]byte(recordMagic),
+			[]byte{2},
+			uint32ToBytes(now),
+			uint16ToBytes(200),
+			uint32ToBytes(600),
+			uint32ToBytes(10),
+			uint32ToBytes(20),
+			uint32ToBytes(uint32(len(header))),
+			uint32ToBytes(uint32(len(body))),
+			uint32ToBytes(0),
+			uint32ToBytes(0),
+			header,
+			body,
+		}, nil)
+		c.store.Put(key, data)
+		resp, err := c.GetResponse(key)
+		if err != nil {
+			t.Fatalf("get version 2 response fail, %v", err)
+		}
+		if resp.TTL != 600 || resp.StaleWhileRevalidate != 10 || resp.StaleIfError != 20 || len(resp.Vary) != 0 {
+			t.Fatalf("the version 2 record header is wrong")
+		}
+		if resp.Header.Get("X-Token") != "A" || !bytes.Equal(resp.Body, body) {
+			t.Fatalf("the version 2 record data is wrong")
+		}
+	})
+
+	t.Run("invalid record", func(t *testing.T) {
+		key := []byte("invalid record")
+		c.store.Put(key, []byte("invalid"))
+		_, err := c.GetResponse(key)
+		if err != ErrInvalidRecord {
+			t.Fatalf("invalid record should return error")
+		}
+	})
+}
+
 func TestRequestStatus(t *testing.T) {
 	c := Client{
 		Path: dbPath,
@@ -450,3 +549,256 @@ func TestGetFetchingList(t *testing.T) {
 		t.Fatalf("get fetch list fail")
 	}
 }
+
+func TestEvict(t *testing.T) {
+	save := func(c *Client, key string) {
+		k := []byte(key)
+		c.GetRequestStatus(k)
+		c.SaveResponse(k, &Response{
+			StatusCode: 200,
+			TTL:        300,
+			Body:       []byte("evict data"),
+		})
+		c.Cacheable(k, 300)
+	}
+	t.Run("lru", func(t *testing.T) {
+		c := Client{
+			Driver:     MemoryStorage,
+			MaxEntries: 2,
+		}
+		err := c.Init()
+		if err != nil {
+			t.Fatalf("cache init fail, %v", err)
+		}
+		defer c.Close()
+		save(&c, "1")
+		save(&c, "2")
+		// 访问1，则2为最久未访问的缓存
+		c.GetRequestStatus([]byte("1"))
+		save(&c, "3")
+		status, _ := c.GetRequestStatus([]byte("2"))
+		if status != Fetching {
+			t.Fatalf("the least recently used cache should be evicted")
+		}
+		status, _ = c.GetRequestStatus([]byte("1"))
+		if status != Cacheable {
+			t.Fatalf("the recently used cache should not be evicted")
+		}
+		if c.GetStats().Evicted != 1 {
+			t.Fatalf("the evicted count should be 1")
+		}
+	})
+
+	t.Run("lfu", func(t *testing.T) {
+		c := Client{
+			Driver:         MemoryStorage,
+			MaxEntries:     2,
+			EvictionPolicy: LFU,
+		}
+		err := c.Init()
+		if err != nil {
+			t.Fatalf("cache init fail, %v", err)
+		}
+		defer c.Close()
+		save(&c, "1")
+		save(&c, "2")
+		c.GetRequestStatus([]byte("1"))
+		c.GetRequestStatus([]byte("1"))
+		c.GetRequestStatus([]byte("2"))
+		save(&c, "3")
+		status, _ := c.GetRequestStatus([]byte("2"))
+		if status != Fetching {
+			t.Fatalf("the least frequently used cache should be evicted")
+		}
+		resp, _ := c.GetResponse([]byte("2"))
+		if resp != nil {
+			t.Fatalf("the data of evicted cache should be deleted")
+		}
+	})
+
+	t.Run("max size", func(t *testing.T) {
+		c := Client{
+			Driver:  MemoryStorage,
+			MaxSize: 100,
+		}
+		err := c.Init()
+		if err != nil {
+			t.Fatalf("cache init fail, %v", err)
+		}
+		defer c.Close()
+		for i := 0; i < 10; i++ {
+			save(&c, strconv.Itoa(i))
+		}
+		if c.size > c.MaxSize {
+			t.Fatalf("the cache size should not be bigger than max size")
+		}
+		if c.GetStats().Evicted == 0 {
+			t.Fatalf("cache should be evicted by size")
+		}
+	})
+}
+
+func TestRestore(t *testing.T) {
+	path := "/tmp/test-restore.cache"
+	c := Client{
+		Path: path,
+	}
+	err := c.Init()
+	if err != nil {
+		t.Fatalf("cache init fail, %v", err)
+	}
+	now := uint32(time.Now().Unix())
+	cacheableKey := []byte("restore cacheable")
+	expiredKey := []byte("restore expired")
+	baseKey := []byte("restore vary")
+	varyKey := []byte("restore vary X-Lang=en")
+	c.SaveResponse(cacheableKey, &Response{
+		CreatedAt:  now,
+		StatusCode: 200,
+		TTL:        300,
+		Body:       []byte("cacheable"),
+	})
+	c.SaveResponse(expiredKey, &Response{
+		CreatedAt:  now - 100,
+		StatusCode: 200,
+		TTL:        10,
+		Body:       []byte("expired"),
+	})
+	c.SaveResponse(varyKey, &Response{
+		CreatedAt:  now,
+		StatusCode: 200,
+		TTL:        300,
+		Body:       []byte("en"),
+		BaseKey:    baseKey,
+		Vary:       []string{"X-Lang"},
+	})
+	c.Close()
+
+	c = Client{
+		Path: path,
+	}
+	err = c.Init()
+	if err != nil {
+		t.Fatalf("cache init fail, %v", err)
+	}
+	defer c.Close()
+	status, _ := c.GetRequestStatus(cacheableKey)
+	if status != Cacheable {
+		t.Fatalf("the not expired response should be restored as cacheable")
+	}
+	resp, _ := c.GetResponse(cacheableKey)
+	if resp == nil || string(resp.Body) != "cacheable" {
+		t.Fatalf("get restored response fail")
+	}
+	resp, _ = c.GetResponse(expiredKey)
+	if resp != nil {
+		t.Fatalf("the expired response should be deleted")
+	}
+	status, _ = c.GetRequestStatus(expiredKey)
+	if status != Fetching {
+		t.Fatalf("the expired response should not be restored")
+	}
+	// Vary对应版本的缓存，恢复其基础标记的Vary字段
+	vary := c.GetVary(baseKey)
+	if len(vary) != 1 || vary[0] != "X-Lang" {
+		t.Fatalf("the vary of base key should be restored, %v", vary)
+	}
+	resp, _ = c.GetResponse(varyKey)
+	if resp == nil || !bytes.Equal(resp.BaseKey, baseKey) || len(resp.Vary) != 1 {
+		t.Fatalf("get the vary of response fail")
+	}
+}
+
+func TestStale(t *testing.T) {
+	c := Client{
+		Driver: MemoryStorage,
+	}
+	err := c.Init()
+	if err != nil {
+		t.Fatalf("cache init fail, %v", err)
+	}
+	defer c.Close()
+	// 保存缓存数据，并将其设置为已过期
+	saveExpired := func(key []byte, resp *Response) {
+		c.GetRequestStatus(key)
+		c.SaveResponse(key, resp)
+		c.Cacheable(key, resp.TTL)
+		c.Lock()
+		c.rsMap[string(key)].createdAt -= resp.TTL + 1
+		c.Unlock()
+	}
+
+	t.Run("stale while revalidate", func(t *testing.T) {
+		key := []byte("stale while revalidate")
+		saveExpired(key, &Response{
+			StatusCode:           200,
+			TTL:                  10,
+			StaleWhileRevalidate: 60,
+			Body:                 []byte("stale"),
+		})
+		status, ch := c.GetRequestStatus(key)
+		if status != Stale || ch != nil {
+			t.Fatalf("the expired response should be stale")
+		}
+		if !c.Revalidate(key) {
+			t.Fatalf("the first stale request should revalidate")
+		}
+		if c.Revalidate(key) {
+			t.Fatalf("only one request should revalidate")
+		}
+		status, _ = c.GetRequestStatus(key)
+		if status != Stale {
+			t.Fatalf("the request should be stale while revalidating")
+		}
+		c.Cacheable(key, 10)
+		status, _ = c.GetRequestStatus(key)
+		if status != Cacheable {
+			t.Fatalf("the request should be cacheable after revalidate")
+		}
+	})
+
+	t.Run("stale if error", func(t *testing.T) {
+		key := []byte("stale if error")
+		saveExpired(key, &Response{
+			StatusCode:   200,
+			TTL:          10,
+			StaleIfError: 60,
+			Body:         []byte("stale"),
+		})
+		status, _ := c.GetRequestStatus(key)
+		if status != Fetching {
+			t.Fatalf("the expired response without stale while revalidate should be fetching")
+		}
+		status, ch := c.GetRequestStatus(key)
+		if status != Waiting {
+			t.Fatalf("the next request should be waiting")
+		}
+		done := make(chan int)
+		go func() {
+			done <- <-ch
+		}()
+		resp := c.GetStaleIfError(key)
+		if resp == nil || string(resp.Body) != "stale" {
+			t.Fatalf("get stale if error response fail")
+		}
+		if <-done != Stale {
+			t.Fatalf("the waiting request should be stale")
+		}
+	})
+
+	t.Run("no stale", func(t *testing.T) {
+		key := []byte("no stale")
+		saveExpired(key, &Response{
+			StatusCode: 200,
+			TTL:        10,
+			Body:       []byte("stale"),
+		})
+		status, _ := c.GetRequestStatus(key)
+		if status != Fetching {
+			t.Fatalf("the expired response should be fetching")
+		}
+		if c.GetStaleIfError(key) != nil {
+			t.Fatalf("the response without stale if error should not be used")
+		}
+	})
+}
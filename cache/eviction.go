@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"container/list"
+)
+
+type (
+	// evictionList 记录已保存缓存数据的淘汰顺序，增删与访问的更新都为O(1)，
+	// 非并发安全，需要在evictLock中调用
+	evictionList interface {
+		// add 保存缓存数据时调用（已存在的则重置访问记录）
+		add(key string)
+		// hit 缓存被访问时调用
+		hit(key string)
+		// remove 删除缓存数据时调用
+		remove(key string)
+		// each 按淘汰的顺序遍历，fn返回false则停止（fn中可删除当前的key）
+		each(fn func(key string) bool)
+	}
+	// lruList 最久未被访问的在最后
+	lruList struct {
+		items    *list.List
+		elements map[string]*list.Element
+	}
+	// lfuList 按访问次数分组（次数由小至大），相同次数的最久未被访问的在最后
+	lfuList struct {
+		freqs    *list.List
+		elements map[string]*list.Element
+	}
+	// lfuFreq 访问次数相同的缓存
+	lfuFreq struct {
+		hits  uint32
+		items *list.List
+	}
+	// lfuItem lfu中的缓存，freq为所在分组的element
+	lfuItem struct {
+		key  string
+		freq *list.Element
+	}
+)
+
+// newEvictionList 根据淘汰策略生成淘汰顺序的记录
+func newEvictionList(policy string) evictionList {
+	if policy == LFU {
+		return &lfuList{
+			freqs:    list.New(),
+			elements: make(map[string]*list.Element),
+		}
+	}
+	return &lruList{
+		items:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+func (l *lruList) add(key string) {
+	if e := l.elements[key]; e != nil {
+		l.items.MoveToFront(e)
+		return
+	}
+	l.elements[key] = l.items.PushFront(key)
+}
+
+func (l *lruList) hit(key string) {
+	if e := l.elements[key]; e != nil {
+		l.items.MoveToFront(e)
+	}
+}
+
+func (l *lruList) remove(key string) {
+	if e := l.elements[key]; e != nil {
+		l.items.Remove(e)
+		delete(l.elements, key)
+	}
+}
+
+func (l *lruList) each(fn func(key string) bool) {
+	var prev *list.Element
+	for e := l.items.Back(); e != nil; e = prev {
+		prev = e.Prev()
+		if !fn(e.Value.(string)) {
+			return
+		}
+	}
+}
+
+// moveTo 将缓存移至访问次数为hits的分组（分组在at之后，不存在则创建）
+func (l *lfuList) moveTo(key string, hits uint32, at *list.Element) {
+	var freq *list.Element
+	if at == nil {
+		freq = l.freqs.Front()
+	} else {
+		freq = at.Next()
+	}
+	if freq == nil || freq.Value.(*lfuFreq).hits != hits {
+		f := &lfuFreq{
+			hits:  hits,
+			items: list.New(),
+		}
+		if at == nil {
+			freq = l.freqs.PushFront(f)
+		} else {
+			freq = l.freqs.InsertAfter(f, at)
+		}
+	}
+	l.elements[key] = freq.Value.(*lfuFreq).items.PushFront(&lfuItem{
+		key:  key,
+		freq: freq,
+	})
+}
+
+// detach 将缓存从所在的分组中删除，分组为空则删除分组，返回前一个分组
+func (l *lfuList) detach(e *list.Element) *list.Element {
+	freq := e.Value.(*lfuItem).freq
+	f := freq.Value.(*lfuFreq)
+	f.items.Remove(e)
+	prev := freq.Prev()
+	if f.items.Len() != 0 {
+		return freq
+	}
+	l.freqs.Remove(freq)
+	return prev
+}
+
+func (l *lfuList) add(key string) {
+	l.remove(key)
+	l.moveTo(key, 0, nil)
+}
+
+func (l *lfuList) hit(key string) {
+	e := l.elements[key]
+	if e == nil {
+		return
+	}
+	hits := e.Value.(*lfuItem).freq.Value.(*lfuFreq).hits + 1
+	l.moveTo(key, hits, l.detach(e))
+}
+
+func (l *lfuList) remove(key string) {
+	if e := l.elements[key]; e != nil {
+		l.detach(e)
+		delete(l.elements, key)
+	}
+}
+
+func (l *lfuList) each(fn func(key string) bool) {
+	var nextFreq *list.Element
+	for freq := l.freqs.Front(); freq != nil; freq = nextFreq {
+		nextFreq = freq.Next()
+		var prev *list.Element
+		for e := freq.Value.(*lfuFreq).items.Back(); e != nil; e = prev {
+			prev = e.Prev()
+			if !fn(e.Value.(*lfuItem).key) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+)
+
+// getEvictionOrder 获取淘汰的顺序（以逗号分隔）
+func getEvictionOrder(l evictionList) string {
+	keys := make([]string, 0)
+	l.each(func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return strings.Join(keys, ",")
+}
+
+func TestEvictionList(t *testing.T) {
+	t.Run("lru", func(t *testing.T) {
+		l := newEvictionList(LRU)
+		l.add("1")
+		l.add("2")
+		l.add("3")
+		l.hit("1")
+		// 未保存的key不会增加
+		l.hit("4")
+		if getEvictionOrder(l) != "2,3,1" {
+			t.Fatalf("the lru order should be 2,3,1, but %s", getEvictionOrder(l))
+		}
+		l.add("2")
+		l.remove("3")
+		if getEvictionOrder(l) != "1,2" {
+			t.Fatalf("the lru order should be 1,2, but %s", getEvictionOrder(l))
+		}
+	})
+
+	t.Run("lfu", func(t *testing.T) {
+		l := newEvictionList(LFU)
+		l.add("1")
+		l.add("2")
+		l.add("3")
+		l.hit("1")
+		l.hit("1")
+		l.hit("2")
+		l.hit("4")
+		if getEvictionOrder(l) != "3,2,1" {
+			t.Fatalf("the lfu order should be 3,2,1, but %s", getEvictionOrder(l))
+		}
+		// 访问次数相同的，最久未被访问的先淘汰
+		l.hit("3")
+		if getEvictionOrder(l) != "2,3,1" {
+			t.Fatalf("the lfu order should be 2,3,1, but %s", getEvictionOrder(l))
+		}
+		// 重新保存的数据重置访问次数
+		l.add("1")
+		if getEvictionOrder(l) != "1,2,3" {
+			t.Fatalf("the lfu order should be 1,2,3, but %s", getEvictionOrder(l))
+		}
+	})
+
+	t.Run("remove while iterating", func(t *testing.T) {
+		for _, policy := range []string{LRU, LFU} {
+			l := newEvictionList(policy)
+			l.add("1")
+			l.add("2")
+			l.add("3")
+			l.hit("3")
+			count := 0
+			l.each(func(key string) bool {
+				l.remove(key)
+				count++
+				return true
+			})
+			if count != 3 || getEvictionOrder(l) != "" {
+				t.Fatalf("remove while iterating fail(%s)", policy)
+			}
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// getTags 获取响应头中的缓存标签（多个标签以空格分隔）
+func getTags(header http.Header, field string) []string {
+	if len(field) == 0 || header == nil {
+		return nil
+	}
+	values := header[http.CanonicalHeaderKey(field)]
+	if len(values) == 0 {
+		return nil
+	}
+	tags := make([]string, 0)
+	exists := make(map[string]bool)
+	for _, value := range values {
+		for _, tag := range strings.Fields(value) {
+			if exists[tag] {
+				continue
+			}
+			exists[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// indexTags 记录缓存数据的标签（需要在lock中调用）
+func (c *Client) indexTags(k string, tags []string) {
+	c.unindexTags(k)
+	if len(tags) == 0 {
+		return
+	}
+	c.keyTagsMap[k] = tags
+	for _, tag := range tags {
+		keys := c.tagMap[tag]
+		if keys == nil {
+			keys = make(map[string]bool)
+			c.tagMap[tag] = keys
+		}
+		keys[k] = true
+	}
+}
+
+// unindexTags 删除缓存数据的标签（需要在lock中调用）
+func (c *Client) unindexTags(k string) {
+	tags := c.keyTagsMap[k]
+	if len(tags) == 0 {
+		return
+	}
+	for _, tag := range tags {
+		keys := c.tagMap[tag]
+		delete(keys, k)
+		if len(keys) == 0 {
+			delete(c.tagMap, tag)
+		}
+	}
+	delete(c.keyTagsMap, k)
+}
+
+// expire 将缓存设置为已过期，但保留缓存数据（需要在lock中调用），
+// 过期后的数据可用于条件请求与stale-if-error
+func (c *Client) expire(k string) {
+	rs := c.rsMap[k]
+	// fetching中的请求，将其原有的缓存设置为过期
+	if rs != nil && rs.status == Fetching {
+		rs = rs.stale
+	}
+	if rs == nil || rs.status != Cacheable || isExpired(rs) {
+		return
+	}
+	now := uint32(time.Now().Unix())
+	// 调整创建时间使其刚好过期，过期后的可用时间从此刻开始计算
+	createdAt := now - rs.ttl - 1
+	rs.createdAt = createdAt
+	// 同时更新存储中的创建时间，避免重启恢复后又变为有效
+	key := []byte(k)
+	data, err := c.store.Get(key)
+	if err != nil || len(data) < recordV1HeaderLength || string(data[0:4]) != recordMagic {
+		return
+	}
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	copy(buf[5:9], uint32ToBytes(createdAt))
+	c.store.Put(key, buf)
+}
+
+// purge 删除缓存数据（需要在lock中调用），fetching中的请求保留其状态，只删除过期数据，
+// 如果soft为true，则只设置为过期
+func (c *Client) purge(k string, soft bool) {
+	if soft {
+		c.expire(k)
+		return
+	}
+	c.unindexTags(k)
+	c.store.Delete([]byte(k))
+	rs := c.rsMap[k]
+	if rs == nil {
+		return
+	}
+	c.setStoredSize(k, rs, 0)
+	if rs.status == Fetching {
+		rs.stale = nil
+		return
+	}
+	delete(c.rsMap, k)
+}
+
+// PurgeTag 删除包含该标签的所有缓存，返回删除的数量（soft为true则只设置为过期）
+func (c *Client) PurgeTag(tag string, soft bool) int {
+	c.Lock()
+	defer c.Unlock()
+	keys := c.tagMap[tag]
+	count := 0
+	for k := range keys {
+		c.purge(k, soft)
+		count++
+	}
+	return count
+}
+
+// PurgeMatch 删除key符合条件的所有缓存，返回删除的数量（soft为true则只设置为过期）
+func (c *Client) PurgeMatch(match func(key string) bool, soft bool) int {
+	c.Lock()
+	defer c.Unlock()
+	count := 0
+	for k, rs := range c.rsMap {
+		// 无缓存数据的（如hit for pass）忽略
+		if rs.size == 0 && rs.status != Cacheable {
+			continue
+		}
+		if !match(k) {
+			continue
+		}
+		c.purge(k, soft)
+		count++
+	}
+	return count
+}
+
+// Expire 将缓存设置为已过期，但保留缓存数据（soft purge）
+func (c *Client) Expire(key []byte) {
+	c.Lock()
+	defer c.Unlock()
+	c.expire(string(key))
+}
@@ -0,0 +1,161 @@
+package cache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetTags(t *testing.T) {
+	header := make(http.Header)
+	header.Add("Surrogate-Key", "article-1  home")
+	header.Add("Surrogate-Key", "home list")
+	tags := getTags(header, "surrogate-key")
+	if strings.Join(tags, ",") != "article-1,home,list" {
+		t.Fatalf("get tags fail")
+	}
+	if len(getTags(header, "")) != 0 {
+		t.Fatalf("get tags without tag header should be empty")
+	}
+}
+
+func TestPurgeTag(t *testing.T) {
+	c := Client{
+		Driver:    MemoryStorage,
+		TagHeader: "Surrogate-Key",
+	}
+	err := c.Init()
+	if err != nil {
+		t.Fatalf("cache init fail, %v", err)
+	}
+	defer c.Close()
+	save := func(key, tags string) {
+		header := make(http.Header)
+		header.Set("Surrogate-Key", tags)
+		c.GetRequestStatus([]byte(key))
+		c.SaveResponse([]byte(key), &Response{
+			StatusCode: 200,
+			TTL:        60,
+			Header:     header,
+			Body:       []byte(key),
+		})
+		c.Cacheable([]byte(key), 60)
+	}
+	save("/articles/1", "article-1 home")
+	save("/articles/2", "article-2 home")
+	save("/users", "user")
+
+	t.Run("purge tag", func(t *testing.T) {
+		if c.PurgeTag("home", false) != 2 {
+			t.Fatalf("purge tag should remove two cache")
+		}
+		resp, _ := c.GetResponse([]byte("/articles/1"))
+		if resp != nil {
+			t.Fatalf("the cache of tag should be removed")
+		}
+		status, _ := c.GetRequestStatus([]byte("/users"))
+		if status != Cacheable {
+			t.Fatalf("the cache without tag should not be removed")
+		}
+		if c.PurgeTag("article-1", false) != 0 {
+			t.Fatalf("the tag index should be removed after purge")
+		}
+	})
+
+	t.Run("remove cache", func(t *testing.T) {
+		c.Remove([]byte("/users"))
+		if c.PurgeTag("user", false) != 0 {
+			t.Fatalf("the tag index should be removed after remove")
+		}
+	})
+}
+
+func TestPurgeMatch(t *testing.T) {
+	c := Client{
+		Driver: MemoryStorage,
+	}
+	err := c.Init()
+	if err != nil {
+		t.Fatalf("cache init fail, %v", err)
+	}
+	defer c.Close()
+	for _, key := range []string{
+		"GET aslant.site /api/products/1",
+		"GET aslant.site /api/products/2",
+		"GET aslant.site /api/users",
+	} {
+		c.GetRequestStatus([]byte(key))
+		c.SaveResponse([]byte(key), &Response{
+			StatusCode: 200,
+			TTL:        60,
+			Body:       []byte(key),
+		})
+		c.Cacheable([]byte(key), 60)
+	}
+	c.GetRequestStatus([]byte("GET aslant.site /api/products/3"))
+	c.HitForPass([]byte("GET aslant.site /api/products/3"), 60)
+
+	count := c.PurgeMatch(func(key string) bool {
+		return strings.HasPrefix(key, "GET aslant.site /api/products")
+	}, false)
+	if count != 2 {
+		t.Fatalf("purge match should remove two cache")
+	}
+	resp, _ := c.GetResponse([]byte("GET aslant.site /api/products/1"))
+	if resp != nil {
+		t.Fatalf("the matched cache should be removed")
+	}
+	status, _ := c.GetRequestStatus([]byte("GET aslant.site /api/users"))
+	if status != Cacheable {
+		t.Fatalf("the cache not matched should not be removed")
+	}
+}
+
+func TestSoftPurge(t *testing.T) {
+	c := Client{
+		Driver:    MemoryStorage,
+		TagHeader: "Surrogate-Key",
+	}
+	err := c.Init()
+	if err != nil {
+		t.Fatalf("cache init fail, %v", err)
+	}
+	defer c.Close()
+	key := []byte("/soft-purge")
+	header := make(http.Header)
+	header.Set("Surrogate-Key", "soft")
+	c.GetRequestStatus(key)
+	c.SaveResponse(key, &Response{
+		StatusCode:   200,
+		TTL:          60,
+		StaleIfError: 60,
+		Header:       header,
+		Body:         []byte("soft"),
+	})
+	c.Cacheable(key, 60)
+
+	if c.PurgeTag("soft", true) != 1 {
+		t.Fatalf("soft purge tag fail")
+	}
+	resp, _ := c.GetResponse(key)
+	if resp == nil || string(resp.Body) != "soft" {
+		t.Fatalf("the body should be kept after soft purge")
+	}
+	if uint32(time.Now().Unix())-resp.CreatedAt <= resp.TTL {
+		t.Fatalf("the stored response should be expired after soft purge")
+	}
+	status, _ := c.GetRequestStatus(key)
+	if status != Fetching {
+		t.Fatalf("the request should be fetching after soft purge")
+	}
+	resp = c.GetStaleIfError(key)
+	if resp == nil || string(resp.Body) != "soft" {
+		t.Fatalf("the soft purged cache should be used for stale if error")
+	}
+	// 已设置为过期的缓存，再次soft purge无影响
+	c.Expire(key)
+	if c.PurgeTag("soft", false) != 1 {
+		t.Fatalf("the tag should be kept after soft purge")
+	}
+}
@@ -0,0 +1,199 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/akrylysov/pogreb"
+)
+
+const (
+	// DiskStorage 使用pogreb保存缓存数据（默认）
+	DiskStorage = "disk"
+	// MemoryStorage 使用内存保存缓存数据
+	MemoryStorage = "memory"
+)
+
+var (
+	// ErrStorageNotSupport 不支持该存储类型
+	ErrStorageNotSupport = errors.New("not support the storage")
+
+	storageCreatorMap = make(map[string]StorageCreator)
+)
+
+type (
+	// Storage 缓存数据的存储接口
+	Storage interface {
+		// Get 获取数据，如果不存在返回nil
+		Get(key []byte) ([]byte, error)
+		// Put 保存数据
+		Put(key, value []byte) error
+		// Delete 删除数据
+		Delete(key []byte) error
+		// Iterate 遍历所有数据，如果fn返回false则停止遍历
+		Iterate(fn func(key, value []byte) bool) error
+		// Size 获取存储数据占用的空间（字节）
+		Size() (int64, error)
+		// Close 关闭存储
+		Close() error
+	}
+	// StorageCreator 根据path创建存储的函数
+	StorageCreator func(path string) (Storage, error)
+
+	// diskStorage 基于pogreb的存储
+	diskStorage struct {
+		db *pogreb.DB
+	}
+	// memoryStorage 基于内存的存储
+	memoryStorage struct {
+		sync.RWMutex
+		data map[string][]byte
+		size int64
+	}
+)
+
+// AddStorage 增加存储的创建函数
+func AddStorage(name string, fn StorageCreator) {
+	storageCreatorMap[name] = fn
+}
+
+// IsStorageSupported 判断是否支持该存储类型
+func IsStorageSupported(name string) bool {
+	return storageCreatorMap[name] != nil
+}
+
+// NewStorage 根据名称创建存储，名称为空则使用disk
+func NewStorage(name, path string) (Storage, error) {
+	if len(name) == 0 {
+		name = DiskStorage
+	}
+	fn := storageCreatorMap[name]
+	if fn == nil {
+		return nil, ErrStorageNotSupport
+	}
+	return fn(path)
+}
+
+func init() {
+	AddStorage(DiskStorage, newDiskStorage)
+	AddStorage(MemoryStorage, newMemoryStorage)
+}
+
+func newDiskStorage(path string) (Storage, error) {
+	os.Remove(path + ".lock")
+	db, err := pogreb.Open(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &diskStorage{
+		db: db,
+	}, nil
+}
+
+func (s *diskStorage) Get(key []byte) ([]byte, error) {
+	return s.db.Get(key)
+}
+
+func (s *diskStorage) Put(key, value []byte) error {
+	return s.db.Put(key, value)
+}
+
+func (s *diskStorage) Delete(key []byte) error {
+	return s.db.Delete(key)
+}
+
+func (s *diskStorage) Iterate(fn func(key, value []byte) bool) error {
+	it := s.db.Items()
+	for {
+		key, value, err := it.Next()
+		if err == pogreb.ErrIterationDone {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fn(key, value) {
+			return nil
+		}
+	}
+}
+
+func (s *diskStorage) Size() (int64, error) {
+	return s.db.FileSize()
+}
+
+func (s *diskStorage) Close() error {
+	return s.db.Close()
+}
+
+func newMemoryStorage(path string) (Storage, error) {
+	return &memoryStorage{
+		data: make(map[string][]byte),
+	}, nil
+}
+
+func (s *memoryStorage) Get(key []byte) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.data[byteSliceToString(key)], nil
+}
+
+func (s *memoryStorage) Put(key, value []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	k := string(key)
+	if v, ok := s.data[k]; ok {
+		s.size -= int64(len(k) + len(v))
+	}
+	s.data[k] = value
+	s.size += int64(len(k) + len(value))
+	return nil
+}
+
+func (s *memoryStorage) Delete(key []byte) error {
+	s.Lock()
+	defer s.Unlock()
+	k := byteSliceToString(key)
+	if v, ok := s.data[k]; ok {
+		s.size -= int64(len(k) + len(v))
+		delete(s.data, k)
+	}
+	return nil
+}
+
+func (s *memoryStorage) Iterate(fn func(key, value []byte) bool) error {
+	s.RLock()
+	// 复制当前的key列表，避免遍历时fn中调用Delete导致死锁
+	keys := make([]string, 0, len(s.data))
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	s.RUnlock()
+	for _, k := range keys {
+		s.RLock()
+		v, ok := s.data[k]
+		s.RUnlock()
+		if !ok {
+			continue
+		}
+		if !fn([]byte(k), v) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (s *memoryStorage) Size() (int64, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.size, nil
+}
+
+func (s *memoryStorage) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.data = make(map[string][]byte)
+	s.size = 0
+	return nil
+}
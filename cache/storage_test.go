@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewStorage(t *testing.T) {
+	t.Run("default storage", func(t *testing.T) {
+		s, err := NewStorage("", dbPath)
+		if err != nil {
+			t.Fatalf("create default storage fail, %v", err)
+		}
+		defer s.Close()
+		if _, ok := s.(*diskStorage); !ok {
+			t.Fatalf("the default storage should be disk")
+		}
+	})
+
+	t.Run("not support storage", func(t *testing.T) {
+		_, err := NewStorage("unknown", dbPath)
+		if err != ErrStorageNotSupport {
+			t.Fatalf("not support storage should return error")
+		}
+	})
+}
+
+func TestStorage(t *testing.T) {
+	for _, name := range []string{DiskStorage, MemoryStorage} {
+		t.Run(name, func(t *testing.T) {
+			s, err := NewStorage(name, dbPath)
+			if err != nil {
+				t.Fatalf("create %s storage fail, %v", name, err)
+			}
+			defer s.Close()
+			key := []byte("storage-test")
+			value := []byte("storage value")
+			err = s.Put(key, value)
+			if err != nil {
+				t.Fatalf("put data fail, %v", err)
+			}
+			data, err := s.Get(key)
+			if err != nil || !bytes.Equal(data, value) {
+				t.Fatalf("get data fail, %v", err)
+			}
+			size, err := s.Size()
+			if err != nil || size == 0 {
+				t.Fatalf("get size fail, %v", err)
+			}
+
+			found := false
+			err = s.Iterate(func(k, v []byte) bool {
+				if bytes.Equal(k, key) {
+					found = bytes.Equal(v, value)
+					return false
+				}
+				return true
+			})
+			if err != nil || !found {
+				t.Fatalf("iterate storage fail, %v", err)
+			}
+
+			err = s.Delete(key)
+			if err != nil {
+				t.Fatalf("delete data fail, %v", err)
+			}
+			data, _ = s.Get(key)
+			if len(data) != 0 {
+				t.Fatalf("the data should be deleted")
+			}
+		})
+	}
+}
+
+func TestMemoryClient(t *testing.T) {
+	c := Client{
+		Driver: MemoryStorage,
+	}
+	err := c.Init()
+	if err != nil {
+		t.Fatalf("memory cache init fail, %v", err)
+	}
+	defer c.Close()
+	key := []byte("memory-client")
+	err = c.SaveResponse(key, &Response{
+		StatusCode: 200,
+		TTL:        60,
+		Body:       []byte("memory"),
+	})
+	if err != nil {
+		t.Fatalf("save response fail, %v", err)
+	}
+	resp, err := c.GetResponse(key)
+	if err != nil || string(resp.Body) != "memory" {
+		t.Fatalf("get response from memory fail, %v", err)
+	}
+	err = c.Remove(key)
+	if err != nil {
+		t.Fatalf("remove response fail, %v", err)
+	}
+	resp, _ = c.GetResponse(key)
+	if resp != nil {
+		t.Fatalf("the response should be removed")
+	}
+}
@@ -2,47 +2,111 @@ package config
 
 import (
 	"io/ioutil"
-	"time"
 
 	"github.com/go-yaml/yaml"
 )
 
 // Director 服务器配置列表
 type Director struct {
-	Name          string
-	Policy        string
-	Ping          string
-	RequestHeader []string `yaml:"requestHeader"`
-	Header        []string
-	Prefixs       []string
-	Hosts         []string
-	Backends      []string
-	Rewrites      []string
+	Name           string          `json:"name,omitempty"`
+	Policy         string          `json:"policy,omitempty"`
+	Ping           string          `json:"ping,omitempty"`
+	RequestHeader  []string        `yaml:"requestHeader" json:"requestHeader,omitempty"`
+	Header         []string        `json:"header,omitempty"`
+	Prefixs        []string        `json:"prefixs,omitempty"`
+	Hosts          []string        `json:"hosts,omitempty"`
+	Backends       []string        `json:"backends,omitempty"`
+	Rewrites       []string        `json:"rewrites,omitempty"`
+	Retry          *Retry          `json:"retry,omitempty"`
+	CircuitBreaker *CircuitBreaker `yaml:"circuitBreaker" json:"circuitBreaker,omitempty"`
+	HealthCheck    *HealthCheck    `yaml:"healthCheck" json:"healthCheck,omitempty"`
+	SlowStart      Duration        `yaml:"slowStart" json:"slowStart,omitempty"`
+	Sticky         *Sticky         `json:"sticky,omitempty"`
+	Discovery      *Discovery      `json:"discovery,omitempty"`
+}
+
+// Discovery backend自动发现的配置
+type Discovery struct {
+	Type     string   `json:"type,omitempty"`
+	File     string   `json:"file,omitempty"`
+	Host     string   `json:"host,omitempty"`
+	SRV      bool     `yaml:"srv" json:"srv,omitempty"`
+	Scheme   string   `json:"scheme,omitempty"`
+	Port     int      `json:"port,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// Sticky sticky策略的cookie配置
+type Sticky struct {
+	Cookie string   `json:"cookie,omitempty"`
+	Secret string   `json:"secret,omitempty"`
+	MaxAge Duration `yaml:"maxAge" json:"maxAge,omitempty"`
+	Path   string   `json:"path,omitempty"`
+}
+
+// HealthCheck 主动健康检测的配置
+type HealthCheck struct {
+	Interval Duration `json:"interval,omitempty"`
+	Timeout  Duration `json:"timeout,omitempty"`
+	Rise     int      `json:"rise,omitempty"`
+	Fall     int      `json:"fall,omitempty"`
+	Status   []int    `json:"status,omitempty"`
+	Body     string   `json:"body,omitempty"`
+	Host     string   `json:"host,omitempty"`
+	TCP      bool     `json:"tcp,omitempty"`
+}
+
+// CircuitBreaker 根据实际请求结果熔断backend的配置
+type CircuitBreaker struct {
+	Failures int      `json:"failures,omitempty"`
+	CoolDown Duration `yaml:"coolDown" json:"coolDown,omitempty"`
+	Status   []int    `json:"status,omitempty"`
+}
+
+// Retry 请求失败时的重试配置
+type Retry struct {
+	Attempts int      `json:"attempts,omitempty"`
+	Status   []int    `json:"status,omitempty"`
+	Error    bool     `json:"error,omitempty"`
+	Timeout  Duration `json:"timeout,omitempty"`
 }
 
 // Config 应用配置
 type Config struct {
-	Name                 string        `yaml:"name"`
-	Listen               string        `yaml:"listen"`
-	DB                   string        `yaml:"db"`
-	Identity             string        `yaml:"identity"`
-	ETag                 bool          `yaml:"etag"`
-	Header               []string      `yaml:"header"`
-	RequestHeader        []string      `yaml:"requestHeader"`
-	EnableServerTiming   bool          `yaml:"enableServerTiming"`
-	CompressMinLength    int           `yaml:"compressMinLength"`
-	CompressLevel        int           `yaml:"compressLevel"`
-	Concurrency          int           `yaml:"concurrency"`
-	Directors            []*Director   `yaml:"directors"`
-	TextTypes            []string      `yaml:"textTypes"`
-	Rewrites             []string      `yaml:"rewrites"`
-	ExpiredClearInterval time.Duration `yaml:"expiredClearInterval"`
-	ConnectTimeout       time.Duration `yaml:"connectTimeout"`
-	LogFormat            string        `yaml:"logFormat"`
-	AccessLog            string        `yaml:"accessLog"`
-	LogType              string        `yaml:"logType"`
-	AdminPath            string        `yaml:"adminPath"`
-	AdminToken           string        `yaml:"adminToken"`
+	Name                 string      `yaml:"name" json:"name,omitempty"`
+	Listen               string      `yaml:"listen" json:"listen,omitempty"`
+	DB                   string      `yaml:"db" json:"db,omitempty"`
+	Storage              string      `yaml:"storage" json:"storage,omitempty"`
+	MaxCacheSize         int64       `yaml:"maxCacheSize" json:"maxCacheSize,omitempty"`
+	MaxCacheEntries      int         `yaml:"maxCacheEntries" json:"maxCacheEntries,omitempty"`
+	EvictionPolicy       string      `yaml:"evictionPolicy" json:"evictionPolicy,omitempty"`
+	TagHeader            string      `yaml:"tagHeader" json:"tagHeader,omitempty"`
+	Identity             string      `yaml:"identity" json:"identity,omitempty"`
+	ETag                 bool        `yaml:"etag" json:"etag,omitempty"`
+	Header               []string    `yaml:"header" json:"header,omitempty"`
+	RequestHeader        []string    `yaml:"requestHeader" json:"requestHeader,omitempty"`
+	EnableServerTiming   bool        `yaml:"enableServerTiming" json:"enableServerTiming,omitempty"`
+	CompressMinLength    int         `yaml:"compressMinLength" json:"compressMinLength,omitempty"`
+	CompressLevel        int         `yaml:"compressLevel" json:"compressLevel,omitempty"`
+	Concurrency          int         `yaml:"concurrency" json:"concurrency,omitempty"`
+	Directors            []*Director `yaml:"directors" json:"directors,omitempty"`
+	TextTypes            []string    `yaml:"textTypes" json:"textTypes,omitempty"`
+	Rewrites             []string    `yaml:"rewrites" json:"rewrites,omitempty"`
+	ExpiredClearInterval Duration    `yaml:"expiredClearInterval" json:"expiredClearInterval,omitempty"`
+	ConnectTimeout       Duration    `yaml:"connectTimeout" json:"connectTimeout,omitempty"`
+	WaitTimeout          Duration    `yaml:"waitTimeout" json:"waitTimeout,omitempty"`
+	StreamPass           bool        `yaml:"streamPass" json:"streamPass,omitempty"`
+	StreamThreshold      int64       `yaml:"streamThreshold" json:"streamThreshold,omitempty"`
+	StreamCache          bool        `yaml:"streamCache" json:"streamCache,omitempty"`
+	UpgradeIdleTimeout   Duration    `yaml:"upgradeIdleTimeout" json:"upgradeIdleTimeout,omitempty"`
+	LogFormat            string      `yaml:"logFormat" json:"logFormat,omitempty"`
+	AccessLog            string      `yaml:"accessLog" json:"accessLog,omitempty"`
+	LogType              string      `yaml:"logType" json:"logType,omitempty"`
+	AdminPath            string      `yaml:"adminPath" json:"adminPath,omitempty"`
+	AdminToken           string      `yaml:"adminToken" json:"adminToken,omitempty"`
+	// 配置文件中各字段的行号与不支持的字段（用于校验）
+	lines         map[string]int
+	unknownFields []string
 }
 
 // InitFromFile 获取默认的配置
@@ -53,5 +117,44 @@ func InitFromFile(file string) (c *Config, err error) {
 	}
 	c = &Config{}
 	err = yaml.Unmarshal(buf, c)
+	if err != nil {
+		return
+	}
+	c.lines = getLines(buf)
+	// 使用strict模式获取不支持的字段（如字段名拼写错误），由Validate返回
+	if e, ok := yaml.UnmarshalStrict(buf, &Config{}).(*yaml.TypeError); ok {
+		c.unknownFields = e.Errors
+	}
 	return
 }
+
+// WriteToFile 将配置保存至文件（文件中的注释不会保留）
+func (c *Config) WriteToFile(file string) error {
+	buf, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, buf, 0644)
+}
+
+// cloneStrings 复制字符串slice
+func cloneStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	result := make([]string, len(values))
+	copy(result, values)
+	return result
+}
+
+// Clone 复制director的配置（修改复制的配置不影响原有配置）
+func (d *Director) Clone() *Director {
+	result := *d
+	result.RequestHeader = cloneStrings(d.RequestHeader)
+	result.Header = cloneStrings(d.Header)
+	result.Prefixs = cloneStrings(d.Prefixs)
+	result.Hosts = cloneStrings(d.Hosts)
+	result.Backends = cloneStrings(d.Backends)
+	result.Rewrites = cloneStrings(d.Rewrites)
+	return &result
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Duration 配置中的时长，yaml与json中都使用字符串（如"30s"），与配置文件的格式一致
+type Duration time.Duration
+
+var errDurationInvalid = errors.New("duration should be a string like 30s")
+
+// String 输出为字符串，如"1m30s"
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// MarshalJSON 输出为字符串，如"1m30s"
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON 支持字符串（如"30s"）与数值（纳秒）
+func (d *Duration) UnmarshalJSON(buf []byte) error {
+	var v interface{}
+	err := json.Unmarshal(buf, &v)
+	if err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case string:
+		result, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(result)
+	case float64:
+		*d = Duration(value)
+	default:
+		return errDurationInvalid
+	}
+	return nil
+}
+
+// MarshalYAML 输出为字符串，如"1m30s"
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+// UnmarshalYAML 与time.Duration的解析一致，支持字符串（如"30s"）与数值（纳秒）
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v time.Duration
+	err := unmarshal(&v)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
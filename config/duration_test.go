@@ -0,0 +1,121 @@
+package config
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-yaml/yaml"
+)
+
+func TestDirectorJSON(t *testing.T) {
+	d := &Director{
+		Name: "tiny",
+		Backends: []string{
+			"http://127.0.0.1:5018",
+		},
+		RequestHeader: []string{
+			"X-Token:abc",
+		},
+		SlowStart: Duration(30 * time.Second),
+		Retry: &Retry{
+			Attempts: 2,
+			Timeout:  Duration(time.Second),
+		},
+		CircuitBreaker: &CircuitBreaker{
+			CoolDown: Duration(time.Minute),
+		},
+		HealthCheck: &HealthCheck{
+			Interval: Duration(3 * time.Second),
+			Timeout:  Duration(500 * time.Millisecond),
+		},
+		Sticky: &Sticky{
+			MaxAge: Duration(time.Hour),
+		},
+		Discovery: &Discovery{
+			Type:     "dns",
+			Host:     "pike.aslant.site",
+			Interval: Duration(10 * time.Second),
+		},
+	}
+	buf, err := json.Marshal(d)
+	if err != nil {
+		t.Fatalf("json marshal fail, %v", err)
+	}
+	str := string(buf)
+	for _, item := range []string{
+		`"name":"tiny"`,
+		`"requestHeader":["X-Token:abc"]`,
+		`"slowStart":"30s"`,
+		`"retry":{"attempts":2,"timeout":"1s"}`,
+		`"circuitBreaker":{"coolDown":"1m0s"}`,
+		`"healthCheck":{"interval":"3s","timeout":"500ms"}`,
+		`"sticky":{"maxAge":"1h0m0s"}`,
+		`"interval":"10s"`,
+	} {
+		if !strings.Contains(str, item) {
+			t.Fatalf("the json should contain %s, %s", item, str)
+		}
+	}
+
+	result := &Director{}
+	err = json.Unmarshal(buf, result)
+	if err != nil {
+		t.Fatalf("json unmarshal fail, %v", err)
+	}
+	if result.SlowStart != d.SlowStart ||
+		result.Retry.Timeout != d.Retry.Timeout ||
+		result.CircuitBreaker.CoolDown != d.CircuitBreaker.CoolDown ||
+		result.HealthCheck.Timeout != d.HealthCheck.Timeout ||
+		result.Sticky.MaxAge != d.Sticky.MaxAge ||
+		result.Discovery.Interval != d.Discovery.Interval ||
+		result.Name != d.Name || len(result.Backends) != 1 {
+		t.Fatalf("the unmarshal director should be the same as the original")
+	}
+
+	t.Run("invalid duration", func(t *testing.T) {
+		err := json.Unmarshal([]byte(`{"slowStart": "abc"}`), &Director{})
+		if err == nil {
+			t.Fatalf("invalid duration should return error")
+		}
+		err = json.Unmarshal([]byte(`{"slowStart": true}`), &Director{})
+		if err != errDurationInvalid {
+			t.Fatalf("not string duration should return error, %v", err)
+		}
+	})
+}
+
+func TestDurationYAML(t *testing.T) {
+	d := &Director{
+		Name:      "tiny",
+		SlowStart: Duration(30 * time.Second),
+		Retry: &Retry{
+			Timeout: Duration(1500 * time.Millisecond),
+		},
+	}
+	buf, err := yaml.Marshal(d)
+	if err != nil {
+		t.Fatalf("yaml marshal fail, %v", err)
+	}
+	str := string(buf)
+	if !strings.Contains(str, "slowStart: 30s") ||
+		!strings.Contains(str, "timeout: 1.5s") {
+		t.Fatalf("the duration of yaml should be string, %s", str)
+	}
+
+	result := &Director{}
+	err = yaml.Unmarshal(buf, result)
+	if err != nil {
+		t.Fatalf("yaml unmarshal fail, %v", err)
+	}
+	if result.SlowStart != d.SlowStart ||
+		result.Retry.Timeout != d.Retry.Timeout {
+		t.Fatalf("the unmarshal director should be the same as the original")
+	}
+
+	err = yaml.Unmarshal([]byte("slowStart: abc"), &Director{})
+	if err == nil {
+		t.Fatalf("invalid duration should return error")
+	}
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+)
+
+type (
+	// lineFrame 解析yaml行号时的层级（key或数组元素）
+	lineFrame struct {
+		indent int
+		path   string
+		item   bool
+		// 数组元素的个数
+		count int
+	}
+)
+
+// stripComment 删除行中的注释（忽略引号内的#）
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		ch := line[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitKey 拆分"key: value"，如果不是key则返回false
+func splitKey(text string) (key, value string, ok bool) {
+	start := 0
+	if text[0] == '"' || text[0] == '\'' {
+		end := strings.IndexByte(text[1:], text[0])
+		if end == -1 {
+			return
+		}
+		start = end + 2
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] != ':' {
+			continue
+		}
+		if i+1 == len(text) || text[i+1] == ' ' || text[i+1] == '\t' {
+			key = strings.Trim(strings.TrimSpace(text[:i]), `"'`)
+			value = strings.TrimSpace(text[i+1:])
+			ok = true
+			return
+		}
+	}
+	return
+}
+
+// getLines 获取yaml中各路径所在的行号，路径如directors[0].backends[1]，
+// 只处理block风格（flow风格的数组只记录key所在行）
+func getLines(buf []byte) map[string]int {
+	lines := make(map[string]int)
+	stack := []*lineFrame{
+		{
+			indent: -1,
+		},
+	}
+	joinPath := func(parent, key string) string {
+		if len(parent) == 0 {
+			return key
+		}
+		return parent + "." + key
+	}
+	// 多行字符串（| >）的缩进，其内容不解析
+	blockIndent := -1
+	for i, line := range strings.Split(string(buf), "\n") {
+		lineNo := i + 1
+		content := strings.TrimRight(stripComment(line), " \t\r")
+		text := strings.TrimLeft(content, " ")
+		if len(text) == 0 {
+			continue
+		}
+		indent := len(content) - len(text)
+		if blockIndent != -1 {
+			if indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if strings.HasPrefix(text, "---") {
+			continue
+		}
+		// 数组元素
+		if text == "-" || strings.HasPrefix(text, "- ") {
+			for len(stack) > 1 {
+				top := stack[len(stack)-1]
+				if top.indent > indent || (top.item && top.indent >= indent) {
+					stack = stack[:len(stack)-1]
+					continue
+				}
+				break
+			}
+			parent := stack[len(stack)-1]
+			path := parent.path + "[" + strconv.Itoa(parent.count) + "]"
+			parent.count++
+			lines[path] = lineNo
+			stack = append(stack, &lineFrame{
+				indent: indent,
+				path:   path,
+				item:   true,
+			})
+			text = strings.TrimSpace(text[1:])
+			if len(text) == 0 {
+				continue
+			}
+			// 数组元素为map（- name: xxx）
+			indent += 2
+		}
+		key, value, ok := splitKey(text)
+		if !ok {
+			continue
+		}
+		for len(stack) > 1 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		path := joinPath(stack[len(stack)-1].path, key)
+		lines[path] = lineNo
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+			continue
+		}
+		if len(value) == 0 {
+			stack = append(stack, &lineFrame{
+				indent: indent,
+				path:   path,
+			})
+		}
+	}
+	return lines
+}
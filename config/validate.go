@@ -0,0 +1,326 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/vicanso/pike/cache"
+	"github.com/vicanso/pike/pike"
+	"github.com/vicanso/pike/util"
+)
+
+type (
+	// ValidationError 配置校验的错误，Path为yaml中的路径（如directors[0].backends[1]）
+	ValidationError struct {
+		Path    string
+		Line    int
+		Message string
+	}
+	// ValidationErrors 配置校验的所有错误
+	ValidationErrors []*ValidationError
+	// validator 校验配置并记录错误
+	validator struct {
+		lines map[string]int
+		errs  ValidationErrors
+	}
+)
+
+var (
+	unknownFieldReg = regexp.MustCompile(`^line (\d+): (.+)$`)
+)
+
+// Error 输出错误，如"line 3: directors[0].policy: ..."
+func (e *ValidationError) Error() string {
+	msg := e.Message
+	if len(e.Path) != 0 {
+		msg = e.Path + ": " + msg
+	}
+	if e.Line > 0 {
+		msg = "line " + strconv.Itoa(e.Line) + ": " + msg
+	}
+	return msg
+}
+
+// Error 输出所有错误（每行一个）
+func (errs ValidationErrors) Error() string {
+	arr := make([]string, len(errs))
+	for i, err := range errs {
+		arr[i] = err.Error()
+	}
+	return strings.Join(arr, "\n")
+}
+
+// getLine 获取路径所在的行号，如果路径不存在（如未配置的字段）则使用上一级的行号
+func (v *validator) getLine(path string) int {
+	for len(path) != 0 {
+		if line, ok := v.lines[path]; ok {
+			return line
+		}
+		index := strings.LastIndexAny(path, ".[")
+		if index == -1 {
+			break
+		}
+		path = path[:index]
+	}
+	return 0
+}
+
+// add 增加校验错误
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.errs = append(v.errs, &ValidationError{
+		Path:    path,
+		Line:    v.getLine(path),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// err 获取校验结果，无错误则返回nil
+func (v *validator) err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+// joinPath 生成yaml的路径
+func joinPath(parent, key string) string {
+	if len(parent) == 0 {
+		return key
+	}
+	return parent + "." + key
+}
+
+// indexPath 生成数组元素的路径
+func indexPath(parent string, index int) string {
+	return parent + "[" + strconv.Itoa(index) + "]"
+}
+
+// checkDuration 校验时长不能为负数
+func (v *validator) checkDuration(path string, value Duration) {
+	if value < 0 {
+		v.add(path, "duration can not be negative")
+	}
+}
+
+// checkNotNegative 校验数值不能为负数
+func (v *validator) checkNotNegative(path string, value int64) {
+	if value < 0 {
+		v.add(path, "value can not be negative")
+	}
+}
+
+// checkStatus 校验http状态码
+func (v *validator) checkStatus(path string, status []int) {
+	for i, code := range status {
+		if code < 100 || code > 599 {
+			v.add(indexPath(path, i), "status %d is invalid", code)
+		}
+	}
+}
+
+// checkHeader 校验header的配置（name:value）
+func (v *validator) checkHeader(path string, header []string) {
+	for i, item := range header {
+		arr := strings.Split(item, ":")
+		if len(arr) != 2 || len(arr[0]) == 0 {
+			v.add(indexPath(path, i), "header %q should be name:value", item)
+		}
+	}
+}
+
+// checkRewrites 校验rewrite的配置（from:to）
+func (v *validator) checkRewrites(path string, rewrites []string) {
+	for i, item := range rewrites {
+		_, _, err := util.ParseRewrite(item)
+		if err == util.ErrRewriteInvalid {
+			v.add(indexPath(path, i), "rewrite %q should be from:to", item)
+		} else if err != nil {
+			v.add(indexPath(path, i), "rewrite %q is invalid, %v", item, err)
+		}
+	}
+}
+
+// checkBackend 校验backend的配置（url与权重）
+func (v *validator) checkBackend(path, backend string) {
+	fields := strings.Fields(backend)
+	if len(fields) == 0 {
+		v.add(path, "backend can not be empty")
+		return
+	}
+	u, err := url.Parse(fields[0])
+	if err != nil {
+		v.add(path, "backend %q is invalid, %v", fields[0], err)
+	} else if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		v.add(path, "backend %q should be http(s)://host[:port]", fields[0])
+	}
+	for _, option := range fields[1:] {
+		if !strings.HasPrefix(option, pike.WeightOptionPrefix) {
+			v.add(path, "backend option %q is not support", option)
+			continue
+		}
+		weight, err := strconv.Atoi(option[len(pike.WeightOptionPrefix):])
+		if err != nil || weight <= 0 {
+			v.add(path, "backend weight %q should be a positive integer", option)
+		}
+	}
+}
+
+// validate 校验director的配置，path为director在yaml中的路径
+func (d *Director) validate(v *validator, path string) {
+	if len(d.Name) == 0 {
+		v.add(joinPath(path, "name"), "director name can not be empty")
+	}
+	policy := d.Policy
+	if (strings.HasPrefix(policy, pike.HeaderHashPrefix) && len(policy) == len(pike.HeaderHashPrefix)) ||
+		(strings.HasPrefix(policy, pike.CookieHashPrefix) && len(policy) == len(pike.CookieHashPrefix)) {
+		v.add(joinPath(path, "policy"), "policy %q should specify the name", policy)
+	} else if !pike.IsPolicyValid(policy) {
+		v.add(joinPath(path, "policy"), "policy %q is not support", policy)
+	}
+	if len(d.Backends) == 0 && d.Discovery == nil {
+		v.add(joinPath(path, "backends"), "backends can not be empty when discovery is not set")
+	}
+	backends := make(map[string]bool)
+	for i, backend := range d.Backends {
+		p := indexPath(joinPath(path, "backends"), i)
+		v.checkBackend(p, backend)
+		name, _ := pike.ParseBackend(backend)
+		if backends[name] {
+			v.add(p, "backend %q is duplicate", name)
+		}
+		backends[name] = true
+	}
+	for i, host := range d.Hosts {
+		_, err := regexp.Compile(host)
+		if err != nil {
+			v.add(indexPath(joinPath(path, "hosts"), i), "host %q is invalid, %v", host, err)
+		}
+	}
+	for i, prefix := range d.Prefixs {
+		if !strings.HasPrefix(prefix, "/") {
+			v.add(indexPath(joinPath(path, "prefixs"), i), "prefix %q should start with /", prefix)
+		}
+	}
+	v.checkRewrites(joinPath(path, "rewrites"), d.Rewrites)
+	v.checkHeader(joinPath(path, "requestHeader"), d.RequestHeader)
+	v.checkHeader(joinPath(path, "header"), d.Header)
+	v.checkDuration(joinPath(path, "slowStart"), d.SlowStart)
+	if r := d.Retry; r != nil {
+		p := joinPath(path, "retry")
+		v.checkNotNegative(joinPath(p, "attempts"), int64(r.Attempts))
+		v.checkStatus(joinPath(p, "status"), r.Status)
+		v.checkDuration(joinPath(p, "timeout"), r.Timeout)
+	}
+	if cb := d.CircuitBreaker; cb != nil {
+		p := joinPath(path, "circuitBreaker")
+		v.checkNotNegative(joinPath(p, "failures"), int64(cb.Failures))
+		v.checkDuration(joinPath(p, "coolDown"), cb.CoolDown)
+		v.checkStatus(joinPath(p, "status"), cb.Status)
+	}
+	if hc := d.HealthCheck; hc != nil {
+		p := joinPath(path, "healthCheck")
+		v.checkDuration(joinPath(p, "interval"), hc.Interval)
+		v.checkDuration(joinPath(p, "timeout"), hc.Timeout)
+		v.checkNotNegative(joinPath(p, "rise"), int64(hc.Rise))
+		v.checkNotNegative(joinPath(p, "fall"), int64(hc.Fall))
+		v.checkStatus(joinPath(p, "status"), hc.Status)
+	}
+	if s := d.Sticky; s != nil {
+		p := joinPath(path, "sticky")
+		v.checkDuration(joinPath(p, "maxAge"), s.MaxAge)
+		if len(s.Path) != 0 && !strings.HasPrefix(s.Path, "/") {
+			v.add(joinPath(p, "path"), "cookie path %q should start with /", s.Path)
+		}
+	}
+	if dc := d.Discovery; dc != nil {
+		p := joinPath(path, "discovery")
+		if !pike.IsDiscoverySupported(dc.Type) {
+			v.add(joinPath(p, "type"), "discovery type %q is not support", dc.Type)
+		}
+		if dc.Type == "file" && len(dc.File) == 0 {
+			v.add(joinPath(p, "file"), "discovery file can not be empty")
+		}
+		if dc.Type == "dns" && len(dc.Host) == 0 {
+			v.add(joinPath(p, "host"), "discovery host can not be empty")
+		}
+		if len(dc.Scheme) != 0 && dc.Scheme != "http" && dc.Scheme != "https" {
+			v.add(joinPath(p, "scheme"), "scheme should be http or https")
+		}
+		if dc.Port < 0 || dc.Port > 65535 {
+			v.add(joinPath(p, "port"), "port %d is invalid", dc.Port)
+		}
+		v.checkDuration(joinPath(p, "interval"), dc.Interval)
+	}
+}
+
+// Validate 校验director的配置
+func (d *Director) Validate() error {
+	v := &validator{}
+	d.validate(v, "")
+	return v.err()
+}
+
+// Validate 校验配置，返回所有的错误（从文件读取的配置包括行号）
+func (c *Config) Validate() error {
+	v := &validator{
+		lines: c.lines,
+	}
+	// 配置文件中不支持的字段
+	for _, msg := range c.unknownFields {
+		e := &ValidationError{
+			Message: msg,
+		}
+		if result := unknownFieldReg.FindStringSubmatch(msg); result != nil {
+			e.Line, _ = strconv.Atoi(result[1])
+			e.Message = result[2]
+		}
+		v.errs = append(v.errs, e)
+	}
+	if len(c.Listen) != 0 {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			v.add("listen", "listen %q should be host:port", c.Listen)
+		}
+	}
+	if len(c.Storage) != 0 && !cache.IsStorageSupported(c.Storage) {
+		v.add("storage", "storage %q is not support", c.Storage)
+	}
+	if (len(c.Storage) == 0 || c.Storage == cache.DiskStorage) && len(c.DB) == 0 {
+		v.add("db", "db can not be empty when storage is disk")
+	}
+	if len(c.EvictionPolicy) != 0 && c.EvictionPolicy != cache.LRU && c.EvictionPolicy != cache.LFU {
+		v.add("evictionPolicy", "eviction policy should be lru or lfu")
+	}
+	if len(c.LogType) != 0 && c.LogType != "date" && c.LogType != "normal" {
+		v.add("logType", "log type should be date or normal")
+	}
+	v.checkNotNegative("maxCacheSize", c.MaxCacheSize)
+	v.checkNotNegative("maxCacheEntries", int64(c.MaxCacheEntries))
+	v.checkNotNegative("compressMinLength", int64(c.CompressMinLength))
+	v.checkNotNegative("concurrency", int64(c.Concurrency))
+	v.checkNotNegative("streamThreshold", c.StreamThreshold)
+	v.checkDuration("expiredClearInterval", c.ExpiredClearInterval)
+	v.checkDuration("connectTimeout", c.ConnectTimeout)
+	v.checkDuration("waitTimeout", c.WaitTimeout)
+	v.checkDuration("upgradeIdleTimeout", c.UpgradeIdleTimeout)
+	v.checkHeader("header", c.Header)
+	v.checkHeader("requestHeader", c.RequestHeader)
+	v.checkRewrites("rewrites", c.Rewrites)
+	names := make(map[string]bool)
+	for i, d := range c.Directors {
+		path := indexPath("directors", i)
+		if d == nil {
+			v.add(path, "director can not be empty")
+			continue
+		}
+		d.validate(v, path)
+		if len(d.Name) != 0 && names[d.Name] {
+			v.add(joinPath(path, "name"), "director %q is duplicate", d.Name)
+		}
+		names[d.Name] = true
+	}
+	return v.err()
+}
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func initFromString(t *testing.T, data string) *Config {
+	f, err := ioutil.TempFile("", "pike-config")
+	if err != nil {
+		t.Fatalf("create temp file fail, %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(data)
+	f.Close()
+	c, err := InitFromFile(f.Name())
+	if err != nil {
+		t.Fatalf("init config fail, %v", err)
+	}
+	return c
+}
+
+func TestGetLines(t *testing.T) {
+	lines := getLines([]byte(`# comment
+listen: :3015
+directors:
+  -
+    name: tiny
+    policy: "cookie:jt" # comment
+    backends:
+      - http://127.0.0.1:5018 weight=3
+      - http://127.0.0.1:5019
+  - name: npm
+    hosts:
+    - (www.)?npmtrend.com
+    header: |
+      X-Token:abc
+      X-Id:1
+    prefixs: [/api]
+db: /tmp/pike.cache
+`))
+	expected := map[string]int{
+		"listen":                   2,
+		"directors":                3,
+		"directors[0]":             4,
+		"directors[0].name":        5,
+		"directors[0].policy":      6,
+		"directors[0].backends":    7,
+		"directors[0].backends[1]": 9,
+		"directors[1]":             10,
+		"directors[1].name":        10,
+		"directors[1].hosts[0]":    12,
+		"directors[1].header":      13,
+		"directors[1].prefixs":     16,
+		"db":                       17,
+	}
+	for path, line := range expected {
+		if lines[path] != line {
+			t.Fatalf("the line of %s should be %d, but %d", path, line, lines[path])
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("example config", func(t *testing.T) {
+		c, err := InitFromFile("../config.yml")
+		if err != nil {
+			t.Fatalf("init config fail, %v", err)
+		}
+		err = c.Validate()
+		if err != nil {
+			t.Fatalf("the example config should be valid, %v", err)
+		}
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		c := initFromString(t, `listen: :3015
+storage: memory
+evictionPolicy: fifo
+header:
+  - X-Server
+directors:
+  -
+    name: tiny
+    polcy: first
+    policy: abc
+    hosts:
+      - "(abc"
+    rewrites:
+      - /api/(*:/$1
+    backends:
+      - 127.0.0.1:5018
+      - http://127.0.0.1:5019 weight=0
+  -
+    name: tiny
+    retry:
+      status:
+        - 1000
+`)
+		err := c.Validate()
+		errs, ok := err.(ValidationErrors)
+		if !ok {
+			t.Fatalf("should return validation errors, %v", err)
+		}
+		expected := []string{
+			"line 9: field polcy not found in type config.Director",
+			"line 3: evictionPolicy: eviction policy should be lru or lfu",
+			`line 5: header[0]: header "X-Server" should be name:value`,
+			`line 10: directors[0].policy: policy "abc" is not support`,
+			`line 16: directors[0].backends[0]: backend "127.0.0.1:5018" is invalid`,
+			`line 17: directors[0].backends[1]: backend weight "weight=0" should be a positive integer`,
+			`line 12: directors[0].hosts[0]: host "(abc" is invalid`,
+			"line 14: directors[0].rewrites[0]: rewrite",
+			"line 18: directors[1].backends: backends can not be empty when discovery is not set",
+			"line 22: directors[1].retry.status[0]: status 1000 is invalid",
+			`line 19: directors[1].name: director "tiny" is duplicate`,
+		}
+		if len(errs) != len(expected) {
+			t.Fatalf("should return %d errors, but %d:\n%v", len(expected), len(errs), err)
+		}
+		for i, item := range expected {
+			if !strings.HasPrefix(errs[i].Error(), item) {
+				t.Fatalf("the error should be %q, but %q", item, errs[i].Error())
+			}
+		}
+	})
+
+	t.Run("director", func(t *testing.T) {
+		d := &Director{
+			Name: "tiny",
+			Backends: []string{
+				"http://127.0.0.1:5018",
+			},
+			Discovery: &Discovery{
+				Type: "dns",
+			},
+		}
+		err := d.Validate()
+		if err == nil || err.Error() != "discovery.host: discovery host can not be empty" {
+			t.Fatalf("should return discovery error, %v", err)
+		}
+		d.Discovery.Host = "pike.aslant.site"
+		if d.Validate() != nil {
+			t.Fatalf("the director should be valid")
+		}
+	})
+}
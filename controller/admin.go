@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"path"
+	"regexp"
 	"strings"
 	"sync/atomic"
 
@@ -22,8 +23,11 @@ const (
 	cachesURL        = "/cacheds"
 	fetchingsURL     = "/fetchings"
 	cacheRemoveURL   = "/cacheds/"
+	purgeURL         = "/purge"
+	purgeTagURL      = "/purge/tags/"
 	togglePingURL    = "/toggle/ping"
 	pingIsDiabledURL = "/ping/is-disabled"
+	reloadURL        = "/reload"
 	adminToken       = "X-Admin-Token"
 	defaultHTMLFile  = "/index.html"
 )
@@ -31,6 +35,10 @@ const (
 var (
 	// ErrTokenInvalid token校验失败
 	ErrTokenInvalid = pike.NewHTTPError(http.StatusUnauthorized, "token is invalid")
+	// ErrReloadNotSupport 未配置重新加载配置的函数
+	ErrReloadNotSupport = pike.NewHTTPError(http.StatusNotImplemented, "reload is not support")
+	// ErrPurgeConditionInvalid 删除缓存的条件不合法
+	ErrPurgeConditionInvalid = pike.NewHTTPError(http.StatusBadRequest, "purge condition is invalid, prefix glob or regexp should be set")
 )
 
 type (
@@ -41,6 +49,10 @@ type (
 		Client       *cache.Client
 		Directors    pike.Directors
 		DisabledPing *int32
+		// Reload 重新加载配置的函数
+		Reload func() error
+		// UpdateDirectors 修改director配置的函数
+		UpdateDirectors DirectorsUpdater
 	}
 )
 
@@ -114,20 +126,88 @@ func getFetchingList(c *pike.Context, client *cache.Client) error {
 	return c.JSON(m, http.StatusOK)
 }
 
+// isPurgeMethod 判断是否删除缓存的请求方法（DELETE或POST）
+func isPurgeMethod(method string) bool {
+	return method == http.MethodDelete || method == http.MethodPost
+}
+
 // removeCached 删除缓存
 func removeCached(c *pike.Context, client *cache.Client, key string) error {
+	if !isPurgeMethod(c.Request.Method) {
+		return ErrMethodNotAllowed
+	}
 	k, err := base64.StdEncoding.DecodeString(key)
 	if err != nil {
 		return err
 	}
-	err = client.Remove(k)
-	if err != nil {
-		return err
+	// soft purge只设置为过期，保留缓存数据
+	if isSoftPurge(c) {
+		client.Expire(k)
+	} else {
+		err = client.Remove(k)
+		if err != nil {
+			return err
+		}
 	}
 	c.Response.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
+// isSoftPurge 判断是否只设置为过期（soft purge）
+func isSoftPurge(c *pike.Context) bool {
+	soft := c.Request.URL.Query().Get("soft")
+	return soft == "true" || soft == "1"
+}
+
+// purgeTag 删除包含该标签的所有缓存
+func purgeTag(c *pike.Context, client *cache.Client, tag string) error {
+	if !isPurgeMethod(c.Request.Method) {
+		return ErrMethodNotAllowed
+	}
+	m := make(map[string]interface{})
+	m["count"] = client.PurgeTag(tag, isSoftPurge(c))
+	return c.JSON(m, http.StatusOK)
+}
+
+// globToRegexp 将glob转换为正则（*匹配任意字符，?匹配单个字符）
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	return regexp.Compile("^" + expr + "$")
+}
+
+// purgeMatch 删除符合前缀、glob或正则的所有缓存
+func purgeMatch(c *pike.Context, client *cache.Client) error {
+	if !isPurgeMethod(c.Request.Method) {
+		return ErrMethodNotAllowed
+	}
+	query := c.Request.URL.Query()
+	var match func(key string) bool
+	if prefix := query.Get("prefix"); len(prefix) != 0 {
+		match = func(key string) bool {
+			return strings.HasPrefix(key, prefix)
+		}
+	} else {
+		var reg *regexp.Regexp
+		var err error
+		if glob := query.Get("glob"); len(glob) != 0 {
+			reg, err = globToRegexp(glob)
+		} else if expr := query.Get("regexp"); len(expr) != 0 {
+			reg, err = regexp.Compile(expr)
+		} else {
+			return ErrPurgeConditionInvalid
+		}
+		if err != nil {
+			return pike.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		match = reg.MatchString
+	}
+	m := make(map[string]interface{})
+	m["count"] = client.PurgeMatch(match, isSoftPurge(c))
+	return c.JSON(m, http.StatusOK)
+}
+
 // togglePing 切换ping的状态
 func togglePing(c *pike.Context, addr *int32) error {
 	// 0表示非禁用，非0表示禁用
@@ -151,6 +231,33 @@ func getPingIsDisabeld(c *pike.Context, addr *int32) error {
 	return c.JSON(m, http.StatusOK)
 }
 
+// reloadConfig 重新加载配置（配置校验失败则不替换）
+func reloadConfig(c *pike.Context, reload func() error) error {
+	if c.Request.Method != http.MethodPost {
+		return ErrMethodNotAllowed
+	}
+	if reload == nil {
+		return ErrReloadNotSupport
+	}
+	err := reload()
+	if err != nil {
+		return pike.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	m := make(map[string]interface{})
+	m["reloaded"] = true
+	return c.JSON(m, http.StatusOK)
+}
+
+// isAPIURL 判断是否带参数的api（如/purge/tags/article.42）
+func isAPIURL(uri string) bool {
+	for _, prefix := range []string{cacheRemoveURL, directorURL, purgeTagURL} {
+		if strings.HasPrefix(uri, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
 // AdminHandler admin handler
 func AdminHandler(config AdminConfig) pike.Middleware {
 	prefix := config.Prefix
@@ -171,9 +278,8 @@ func AdminHandler(config AdminConfig) pike.Middleware {
 		if uri == "/" {
 			uri = defaultHTMLFile
 		}
-		ext := path.Ext(uri)
-		// 静态文件不校验token
-		if len(ext) != 0 {
+		// 静态文件不校验token（api的参数可能包含"."，如tag与director的名称，不作为静态文件）
+		if len(path.Ext(uri)) != 0 && !isAPIURL(uri) {
 			return serve(c, uri[1:])
 		}
 		if req.Header.Get(adminToken) != config.Token {
@@ -183,6 +289,9 @@ func AdminHandler(config AdminConfig) pike.Middleware {
 		case statsURL:
 			return getStats(c, client)
 		case directorsURL:
+			if req.Method == http.MethodPost {
+				return addDirector(c, config.UpdateDirectors)
+			}
 			return getDirectors(c, directors)
 		case cachesURL:
 			return getCachedList(c, client)
@@ -192,11 +301,22 @@ func AdminHandler(config AdminConfig) pike.Middleware {
 			return togglePing(c, config.DisabledPing)
 		case pingIsDiabledURL:
 			return getPingIsDisabeld(c, config.DisabledPing)
+		case purgeURL:
+			return purgeMatch(c, client)
+		case reloadURL:
+			return reloadConfig(c, config.Reload)
 		}
 		if strings.HasPrefix(uri, cacheRemoveURL) {
 			key := uri[len(cacheRemoveURL):]
 			return removeCached(c, client, key)
 		}
+		if strings.HasPrefix(uri, directorURL) {
+			return handleDirector(c, config.UpdateDirectors, directors, uri[len(directorURL):])
+		}
+		if strings.HasPrefix(uri, purgeTagURL) {
+			tag := uri[len(purgeTagURL):]
+			return purgeTag(c, client, tag)
+		}
 		return nil
 	}
 }
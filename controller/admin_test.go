@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vicanso/pike/cache"
+	"github.com/vicanso/pike/config"
+	"github.com/vicanso/pike/pike"
+)
+
+const (
+	testAdminPrefix = "/pike"
+	testAdminToken  = "token"
+)
+
+// doAdminRequest 调用admin handler处理请求
+func doAdminRequest(conf AdminConfig, method, url string, body string) (*pike.Context, error) {
+	req := httptest.NewRequest(method, testAdminPrefix+url, strings.NewReader(body))
+	req.Header.Set(adminToken, testAdminToken)
+	c := pike.NewContext(req)
+	conf.Prefix = testAdminPrefix
+	conf.Token = testAdminToken
+	fn := AdminHandler(conf)
+	err := fn(c, func() error {
+		return nil
+	})
+	return c, err
+}
+
+// newTestClient 创建测试使用的内存缓存
+func newTestClient(t *testing.T) *cache.Client {
+	client := &cache.Client{
+		Driver:    cache.MemoryStorage,
+		TagHeader: "Surrogate-Key",
+	}
+	err := client.Init()
+	if err != nil {
+		t.Fatalf("cache init fail, %v", err)
+	}
+	return client
+}
+
+func TestPurgeTagRoute(t *testing.T) {
+	client := newTestClient(t)
+	defer client.Close()
+	key := []byte("/articles/42")
+	header := make(http.Header)
+	header.Set("Surrogate-Key", "article.42")
+	client.GetRequestStatus(key)
+	client.SaveResponse(key, &cache.Response{
+		StatusCode: 200,
+		TTL:        60,
+		Header:     header,
+		Body:       []byte("article"),
+	})
+	client.Cacheable(key, 60)
+
+	c, err := doAdminRequest(AdminConfig{
+		Client: client,
+	}, http.MethodDelete, "/purge/tags/article.42", "")
+	if err != nil {
+		t.Fatalf("purge tag fail, %v", err)
+	}
+	data := make(map[string]int)
+	json.Unmarshal(c.Response.Bytes(), &data)
+	if data["count"] != 1 {
+		t.Fatalf("the tag containing dot should be purged, %s", string(c.Response.Bytes()))
+	}
+}
+
+func TestPurgeMethod(t *testing.T) {
+	client := newTestClient(t)
+	defer client.Close()
+	conf := AdminConfig{
+		Client: client,
+	}
+	for _, url := range []string{"/purge?prefix=/api", "/purge/tags/article.42", "/cacheds/L2FwaQ=="} {
+		_, err := doAdminRequest(conf, http.MethodGet, url, "")
+		if err != ErrMethodNotAllowed {
+			t.Fatalf("purge %s by GET should not be allowed, %v", url, err)
+		}
+		for _, method := range []string{http.MethodDelete, http.MethodPost} {
+			_, err := doAdminRequest(conf, method, url, "")
+			if err != nil {
+				t.Fatalf("purge %s by %s fail, %v", url, method, err)
+			}
+		}
+	}
+}
+
+func TestReloadRoute(t *testing.T) {
+	reloaded := 0
+	conf := AdminConfig{
+		Reload: func() error {
+			reloaded++
+			return nil
+		},
+	}
+	_, err := doAdminRequest(conf, http.MethodGet, "/reload", "")
+	if err != ErrMethodNotAllowed || reloaded != 0 {
+		t.Fatalf("reload by GET should not be allowed, %v", err)
+	}
+	c, err := doAdminRequest(conf, http.MethodPost, "/reload", "")
+	if err != nil || reloaded != 1 || c.Response.Status() != http.StatusOK {
+		t.Fatalf("reload by POST fail, %v", err)
+	}
+}
+
+func TestDirectorRoute(t *testing.T) {
+	name := "api.example.com"
+	current := []*config.Director{
+		{
+			Name: name,
+			Backends: []string{
+				"http://127.0.0.1:5001",
+			},
+		},
+	}
+	conf := AdminConfig{
+		Directors: pike.Directors{
+			{
+				Name: name,
+			},
+		},
+		UpdateDirectors: func(update func([]*config.Director) ([]*config.Director, error), persist bool) error {
+			result, err := update(current)
+			if err != nil {
+				return err
+			}
+			current = result
+			return nil
+		},
+	}
+
+	t.Run("get director", func(t *testing.T) {
+		c, err := doAdminRequest(conf, http.MethodGet, "/directors/"+name, "")
+		if err != nil || c.Response.Status() != http.StatusOK {
+			t.Fatalf("get the director with dotted name fail, %v", err)
+		}
+	})
+
+	t.Run("add backend", func(t *testing.T) {
+		_, err := doAdminRequest(conf, http.MethodPost, "/directors/"+name+"/backends", `{"value": "http://127.0.0.1:5002"}`)
+		if err != nil || len(current[0].Backends) != 2 {
+			t.Fatalf("add backend to the director with dotted name fail, %v", err)
+		}
+	})
+
+	t.Run("update director", func(t *testing.T) {
+		_, err := doAdminRequest(conf, http.MethodPut, "/directors/"+name, `{"policy": "first", "backends": ["http://127.0.0.1:5003"], "slowStart": "30s"}`)
+		if err != nil || current[0].Name != name || len(current[0].Backends) != 1 || current[0].SlowStart != config.Duration(30*time.Second) {
+			t.Fatalf("update the director with dotted name fail, %v", err)
+		}
+	})
+
+	t.Run("delete director", func(t *testing.T) {
+		_, err := doAdminRequest(conf, http.MethodDelete, "/directors/"+name, "")
+		if err != nil || len(current) != 0 {
+			t.Fatalf("delete the director with dotted name fail, %v", err)
+		}
+	})
+}
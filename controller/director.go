@@ -0,0 +1,263 @@
+package controller
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	funk "github.com/thoas/go-funk"
+	"github.com/vicanso/pike/config"
+	"github.com/vicanso/pike/pike"
+)
+
+const (
+	directorURL = "/directors/"
+)
+
+var (
+	// ErrDirectorNotFound director不存在
+	ErrDirectorNotFound = pike.NewHTTPError(http.StatusNotFound, "director not found")
+	// ErrDirectorExists director已存在
+	ErrDirectorExists = pike.NewHTTPError(http.StatusBadRequest, "director already exists")
+	// ErrDirectorNameInvalid director的名称不能为空
+	ErrDirectorNameInvalid = pike.NewHTTPError(http.StatusBadRequest, "director name can not be empty")
+	// ErrDirectorFieldInvalid 不支持修改的字段
+	ErrDirectorFieldInvalid = pike.NewHTTPError(http.StatusBadRequest, "field should be backends, hosts, prefixs or rewrites")
+	// ErrDirectorValueInvalid 值不能为空
+	ErrDirectorValueInvalid = pike.NewHTTPError(http.StatusBadRequest, "value can not be empty")
+	// ErrUpdateDirectorsNotSupport 未配置修改director的函数
+	ErrUpdateDirectorsNotSupport = pike.NewHTTPError(http.StatusNotImplemented, "update directors is not support")
+	// ErrBackendStateInvalid backend的状态不合法
+	ErrBackendStateInvalid = pike.NewHTTPError(http.StatusBadRequest, pike.ErrBackendStateInvalid.Error())
+	// ErrBackendNotFound backend不存在
+	ErrBackendNotFound = pike.NewHTTPError(http.StatusNotFound, pike.ErrBackendNotFound.Error())
+	// ErrMethodNotAllowed 不支持的请求方法
+	ErrMethodNotAllowed = pike.NewHTTPError(http.StatusMethodNotAllowed, "method not allowed")
+)
+
+type (
+	// DirectorsUpdater 修改director配置并生效，persist为true则保存至配置文件
+	DirectorsUpdater func(update func([]*config.Director) ([]*config.Director, error), persist bool) error
+	// directorValue 增加backend等时提交的数据
+	directorValue struct {
+		Value string `json:"value"`
+	}
+	// backendState 设置backend状态时提交的数据
+	backendState struct {
+		Backend string `json:"backend"`
+		State   string `json:"state"`
+	}
+)
+
+// isPersist 判断是否保存至配置文件
+func isPersist(c *pike.Context) bool {
+	persist := c.Request.URL.Query().Get("persist")
+	return persist == "true" || persist == "1"
+}
+
+// readJSON 读取请求的json数据
+func readJSON(c *pike.Context, v interface{}) error {
+	buf, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(buf, v)
+	if err != nil {
+		return pike.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+// indexOfDirector 获取director的位置，不存在则返回-1
+func indexOfDirector(directors []*config.Director, name string) int {
+	for i, item := range directors {
+		if item.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// indexOfBackend 获取backend的位置（忽略权重等配置），不存在则返回-1
+func indexOfBackend(backends []string, backend string) int {
+	backend, _ = pike.ParseBackend(backend)
+	for i, item := range backends {
+		if v, _ := pike.ParseBackend(item); v == backend {
+			return i
+		}
+	}
+	return -1
+}
+
+// getDirectorField 获取director中可增删的字段
+func getDirectorField(d *config.Director, field string) *[]string {
+	switch field {
+	case "backends":
+		return &d.Backends
+	case "hosts":
+		return &d.Hosts
+	case "prefixs":
+		return &d.Prefixs
+	case "rewrites":
+		return &d.Rewrites
+	}
+	return nil
+}
+
+// updateDirectors 修改director配置，成功则返回204
+func updateDirectors(c *pike.Context, updater DirectorsUpdater, update func([]*config.Director) ([]*config.Director, error)) error {
+	if updater == nil {
+		return ErrUpdateDirectorsNotSupport
+	}
+	err := updater(update, isPersist(c))
+	if err != nil {
+		if _, ok := err.(*pike.HTTPError); ok {
+			return err
+		}
+		return pike.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// addDirector 增加director
+func addDirector(c *pike.Context, updater DirectorsUpdater) error {
+	d := &config.Director{}
+	err := readJSON(c, d)
+	if err != nil {
+		return err
+	}
+	if len(d.Name) == 0 {
+		return ErrDirectorNameInvalid
+	}
+	return updateDirectors(c, updater, func(directors []*config.Director) ([]*config.Director, error) {
+		if indexOfDirector(directors, d.Name) != -1 {
+			return nil, ErrDirectorExists
+		}
+		return append(directors, d), nil
+	})
+}
+
+// setBackendState 设置backend的状态（enabled draining disabled），只修改运行中的director，不保存至配置文件
+func setBackendState(c *pike.Context, directors pike.Directors, name string) error {
+	if c.Request.Method != http.MethodPut {
+		return ErrMethodNotAllowed
+	}
+	var director *pike.Director
+	for _, item := range directors {
+		if item.Name == name {
+			director = item
+			break
+		}
+	}
+	if director == nil {
+		return ErrDirectorNotFound
+	}
+	data := &backendState{}
+	err := readJSON(c, data)
+	if err != nil {
+		return err
+	}
+	backend, _ := pike.ParseBackend(data.Backend)
+	err = director.SetBackendState(backend, data.State)
+	if err == pike.ErrBackendStateInvalid {
+		return ErrBackendStateInvalid
+	}
+	if err == pike.ErrBackendNotFound {
+		return ErrBackendNotFound
+	}
+	if err != nil {
+		return err
+	}
+	c.Response.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleDirector 获取、修改或删除director，增删director的backends hosts prefixs rewrites，以及设置backend的状态
+func handleDirector(c *pike.Context, updater DirectorsUpdater, directors pike.Directors, path string) error {
+	arr := strings.Split(path, "/")
+	name := arr[0]
+	method := c.Request.Method
+	if len(arr) == 1 {
+		switch method {
+		case http.MethodGet:
+			for _, item := range directors {
+				if item.Name == name {
+					return c.JSON(item, http.StatusOK)
+				}
+			}
+			return ErrDirectorNotFound
+		case http.MethodPut:
+			d := &config.Director{}
+			err := readJSON(c, d)
+			if err != nil {
+				return err
+			}
+			d.Name = name
+			return updateDirectors(c, updater, func(directors []*config.Director) ([]*config.Director, error) {
+				index := indexOfDirector(directors, name)
+				if index == -1 {
+					return nil, ErrDirectorNotFound
+				}
+				directors[index] = d
+				return directors, nil
+			})
+		case http.MethodDelete:
+			return updateDirectors(c, updater, func(directors []*config.Director) ([]*config.Director, error) {
+				index := indexOfDirector(directors, name)
+				if index == -1 {
+					return nil, ErrDirectorNotFound
+				}
+				return append(directors[:index], directors[index+1:]...), nil
+			})
+		}
+		return ErrMethodNotAllowed
+	}
+	field := arr[1]
+	if len(arr) == 2 && field == "states" {
+		return setBackendState(c, directors, name)
+	}
+	if len(arr) != 2 || getDirectorField(&config.Director{}, field) == nil {
+		return ErrDirectorFieldInvalid
+	}
+	var value string
+	switch method {
+	case http.MethodPost:
+		data := &directorValue{}
+		err := readJSON(c, data)
+		if err != nil {
+			return err
+		}
+		value = data.Value
+	case http.MethodDelete:
+		value = c.Request.URL.Query().Get("value")
+	default:
+		return ErrMethodNotAllowed
+	}
+	if len(value) == 0 {
+		return ErrDirectorValueInvalid
+	}
+	return updateDirectors(c, updater, func(directors []*config.Director) ([]*config.Director, error) {
+		index := indexOfDirector(directors, name)
+		if index == -1 {
+			return nil, ErrDirectorNotFound
+		}
+		values := getDirectorField(directors[index], field)
+		current := funk.IndexOfString(*values, value)
+		// backend可能带有权重等配置，使用backend的地址判断
+		if field == "backends" {
+			current = indexOfBackend(*values, value)
+		}
+		if method == http.MethodPost {
+			if current == -1 {
+				*values = append(*values, value)
+			} else {
+				(*values)[current] = value
+			}
+		} else if current != -1 {
+			*values = append((*values)[:current], (*values)[current+1:]...)
+		}
+		return directors, nil
+	})
+}
@@ -37,6 +37,7 @@ const (
 	status         = "status"
 	latency        = "latency"
 	latencyMs      = "latency-ms"
+	retry          = "retry"
 	cookie         = "cookie"
 	payloadSize    = "payload-size"
 	requestHeader  = "requestHeader"
@@ -324,6 +325,8 @@ func Format(c *pike.Context, tags []*Tag, startedAt time.Time, err error) string
 		case latencyMs:
 			ms := util.GetTimeConsuming(startedAt)
 			return strconv.Itoa(ms)
+		case retry:
+			return strconv.Itoa(c.Retries)
 		default:
 			return tag.data
 		}
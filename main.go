@@ -3,14 +3,11 @@ package main
 import (
 	"encoding/json"
 	"flag"
-	"net"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
 	"runtime"
-	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -23,7 +20,6 @@ import (
 	funk "github.com/thoas/go-funk"
 	"github.com/vicanso/pike/cache"
 	"github.com/vicanso/pike/config"
-	"github.com/vicanso/pike/controller"
 	"github.com/vicanso/pike/httplog"
 	"github.com/vicanso/pike/middleware"
 	"github.com/vicanso/pike/pike"
@@ -37,6 +33,7 @@ var commitID = ""
 
 const (
 	defaultExpiredClearInterval = 300 * time.Second
+	defaultHealthCheckInterval  = 5 * time.Second
 	maxIdleConns                = 5 * 1024
 )
 
@@ -139,7 +136,12 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	err = dc.Validate()
 	if funk.ContainsString(args, "test") {
+		if err != nil {
+			log.Errorf("the config file test fail, %s:\n%s", configFile, err.Error())
+			os.Exit(1)
+		}
 		configJSON, err := json.MarshalIndent(dc, "", "  ")
 		if err != nil {
 			panic(err)
@@ -147,6 +149,9 @@ func main() {
 		log.Infof("the config file test done, config: %s", string(configJSON))
 		return
 	}
+	if err != nil {
+		log.Fatalf("the config file is invalid, %s:\n%s", configFile, err.Error())
+	}
 	if funk.ContainsString(args, "check") {
 		check(dc)
 		return
@@ -155,56 +160,21 @@ func main() {
 
 	// 初始化缓存
 	client := &cache.Client{
-		Path: dc.DB,
+		Path:           dc.DB,
+		Driver:         dc.Storage,
+		MaxSize:        dc.MaxCacheSize,
+		MaxEntries:     dc.MaxCacheEntries,
+		EvictionPolicy: dc.EvictionPolicy,
+		TagHeader:      dc.TagHeader,
 	}
 	err = client.Init()
 	if err != nil {
 		panic(err)
 	}
 	defer client.Close()
+	log.Infof("restore %d cacheable response from the cache", client.Size())
 	// 定时任务清除过期缓存
-	go startExpiredClearTask(client, dc.ExpiredClearInterval)
-
-	// 生成director列表
-	directors := make(pike.Directors, 0)
-	for _, item := range dc.Directors {
-		policy := item.Policy
-		err := pike.AddPolicySelectFunc(policy)
-		if err != nil {
-			log.Panic("create policy fail, ", err)
-		}
-		d := &pike.Director{
-			Name:          item.Name,
-			Policy:        policy,
-			Ping:          item.Ping,
-			Backends:      item.Backends,
-			Hosts:         item.Hosts,
-			Prefixs:       item.Prefixs,
-			Rewrites:      item.Rewrites,
-			RequestHeader: item.RequestHeader,
-			Header:        item.Header,
-			TargetURLMap:  make(map[string]*url.URL),
-		}
-		d.Prepare()
-		d.SetTransport(
-			&http.Transport{
-				Proxy: http.ProxyFromEnvironment,
-				DialContext: (&net.Dialer{
-					Timeout:   dc.ConnectTimeout,
-					KeepAlive: 30 * time.Second,
-					DualStack: true,
-				}).DialContext,
-				MaxIdleConns:          maxIdleConns,
-				MaxIdleConnsPerHost:   maxIdleConns,
-				IdleConnTimeout:       10 * time.Second,
-				TLSHandshakeTimeout:   10 * time.Second,
-				ExpectContinueTimeout: 1 * time.Second,
-			})
-		// 定时检测director是否可用
-		go d.StartHealthCheck(5 * time.Second)
-		directors = append(directors, d)
-	}
-	sort.Sort(directors)
+	go startExpiredClearTask(client, time.Duration(dc.ExpiredClearInterval))
 
 	p := pike.New()
 	p.EnableServerTiming = dc.EnableServerTiming
@@ -215,76 +185,19 @@ func main() {
 	setPingDisabled := func() {
 		atomic.StoreInt32(disabledPingValuePoint, 1)
 	}
-	// ping health check
-	p.Use(middleware.Ping(middleware.PingConfig{
-		DisabledPing: disabledPingValuePoint,
-		URL:          "/ping",
-	}))
-
-	// admin管理后台
-	adminConfig := controller.AdminConfig{
-		Prefix:       dc.AdminPath,
-		Token:        dc.AdminToken,
-		Client:       client,
-		Directors:    directors,
-		DisabledPing: disabledPingValuePoint,
+	// 根据配置生成director与中间件，重新加载配置时替换
+	app := &pikeApp{
+		configFile:   configFile,
+		client:       client,
+		p:            p,
+		disabledPing: disabledPingValuePoint,
 	}
-	p.Use(controller.AdminHandler(adminConfig))
-
-	// 配置logger中间件
-	if len(dc.AccessLog) != 0 {
-		logWriter := getLogger(dc)
-		defer logWriter.Close()
-		p.Use(middleware.Logger(middleware.LoggerConfig{
-			LogFormat: dc.LogFormat,
-			Writer:    logWriter,
-		}))
-	}
-
-	p.Use(middleware.Recover(middleware.DefaultRecoverConfig))
-
-	// 初始化中间件的参数
-	initConfig := middleware.InitializationConfig{
-		Header:        dc.Header,
-		RequestHeader: dc.RequestHeader,
-		Concurrency:   dc.Concurrency,
-	}
-	p.Use(middleware.Initialization(initConfig))
-
-	// 生成请求唯一标识与状态中间件
-	p.Use(middleware.Identifier(middleware.IdentifierConfig{
-		Format: dc.Identity,
-	}, client))
-
-	// 获取director的中间件
-	p.Use(middleware.DirectorPicker(middleware.DirectorPickerConfig{}, directors))
-
-	// 缓存读取中间件
-	p.Use(middleware.CacheFetcher(middleware.CacheFetcherConfig{}, client))
-
-	// 代理转发中间件
-	proxyConfig := middleware.ProxyConfig{
-		ETag:     dc.ETag,
-		Rewrites: dc.Rewrites,
-		Timeout:  dc.ConnectTimeout,
+	err = app.apply(dc)
+	if err != nil {
+		log.Panic("create director fail, ", err)
 	}
-	p.Use(middleware.Proxy(proxyConfig))
-
-	// http响应头设置中间件
-	headerSetterConfig := middleware.HeaderSetterConfig{}
-	p.Use(middleware.HeaderSetter(headerSetterConfig))
+	defer app.close()
 
-	// 判断客户端缓存请求是否fresh的中间件
-	freshCheckerConfig := middleware.FreshCheckerConfig{}
-	p.Use(middleware.FreshChecker(freshCheckerConfig))
-
-	// 响应数据处理中间件
-	dispatcherConfig := middleware.DispatcherConfig{
-		CompressTypes:     dc.TextTypes,
-		CompressMinLength: dc.CompressMinLength,
-		CompressLevel:     dc.CompressLevel,
-	}
-	p.Use(middleware.Dispatcher(dispatcherConfig, client))
 	listen := dc.Listen
 	if listen == "" {
 		listen = ":3015"
@@ -293,6 +206,18 @@ func main() {
 	exitSig := make(chan os.Signal, 1)
 	signal.Notify(exitSig, syscall.SIGINT, syscall.SIGTERM)
 
+	// SIGHUP重新加载配置
+	reloadSig := make(chan os.Signal, 1)
+	signal.Notify(reloadSig, syscall.SIGHUP)
+	go func() {
+		for range reloadSig {
+			err := app.reload()
+			if err != nil {
+				log.Error("reload config fail, ", err)
+			}
+		}
+	}()
+
 	go func() {
 		err = p.ListenAndServe(listen)
 		log.Panic("listen and serve fail, ", err)
@@ -1,6 +1,9 @@
 package middleware
 
 import (
+	"context"
+	"net/http"
+
 	"github.com/vicanso/pike/cache"
 	"github.com/vicanso/pike/pike"
 )
@@ -8,9 +11,53 @@ import (
 type (
 	// CacheFetcherConfig cache fetcher配置
 	CacheFetcherConfig struct {
+		// Handler 用于在后台重新获取过期的缓存（stale-while-revalidate），为空则不更新
+		Handler http.Handler
+	}
+	// revalidateContextKey 后台更新缓存的请求的context key
+	revalidateContextKey struct{}
+	// discardResponseWriter 丢弃响应数据的writer
+	discardResponseWriter struct {
+		header http.Header
 	}
 )
 
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(code int) {}
+
+// isRevalidateRequest 判断是否后台更新缓存的请求
+func isRevalidateRequest(req *http.Request) bool {
+	return req.Context().Value(revalidateContextKey{}) != nil
+}
+
+// cloneRevalidateRequest 复制用于后台更新缓存的请求，
+// 原请求在后续的中间件中会被修改（url与header），因此需要在启动goroutine之前复制
+func cloneRevalidateRequest(req *http.Request) *http.Request {
+	ctx := context.WithValue(context.Background(), revalidateContextKey{}, true)
+	r := req.WithContext(ctx)
+	u := *req.URL
+	r.URL = &u
+	r.Header = make(http.Header)
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	return r
+}
+
+// revalidate 在后台重新获取数据，更新过期的缓存
+func revalidate(handler http.Handler, req *http.Request) {
+	handler.ServeHTTP(&discardResponseWriter{
+		header: make(http.Header),
+	}, req)
+}
+
 // CacheFetcher 从缓存中获取数据
 func CacheFetcher(config CacheFetcherConfig, client *cache.Client) pike.Middleware {
 	return func(c *pike.Context, next pike.Next) error {
@@ -21,7 +68,7 @@ func CacheFetcher(config CacheFetcherConfig, client *cache.Client) pike.Middlewa
 			return ErrRequestStatusNotSet
 		}
 		// 如果非cache的
-		if status != cache.Cacheable {
+		if status != cache.Cacheable && status != cache.Stale {
 			done()
 			return next()
 		}
@@ -35,6 +82,10 @@ func CacheFetcher(config CacheFetcherConfig, client *cache.Client) pike.Middlewa
 			done()
 			return err
 		}
+		// 使用过期的数据，后台重新获取数据（只有一个请求会触发）
+		if status == cache.Stale && config.Handler != nil && client.Revalidate(identity) {
+			go revalidate(config.Handler, cloneRevalidateRequest(c.Request))
+		}
 		c.Resp = resp
 		done()
 		return next()
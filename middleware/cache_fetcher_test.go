@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/vicanso/pike/cache"
 	"github.com/vicanso/pike/pike"
@@ -21,7 +22,7 @@ func TestCacheFetcher(t *testing.T) {
 	config := CacheFetcherConfig{}
 	t.Run("cache fetch", func(t *testing.T) {
 		identity := []byte("GET aslant.site /cache")
-		var ttl uint16 = 300
+		var ttl uint32 = 300
 		client.SaveResponse(identity, &cache.Response{
 			TTL: ttl,
 		})
@@ -42,6 +43,49 @@ func TestCacheFetcher(t *testing.T) {
 		}
 	})
 
+	t.Run("stale cache fetch", func(t *testing.T) {
+		identity := []byte("GET aslant.site /stale")
+		client.GetRequestStatus(identity)
+		client.SaveResponse(identity, &cache.Response{
+			TTL:                  1,
+			StaleWhileRevalidate: 60,
+		})
+		client.Cacheable(identity, 1)
+		// 等待缓存过期
+		time.Sleep(2 * time.Second)
+		status, _ := client.GetRequestStatus(identity)
+		if status != cache.Stale {
+			t.Fatalf("the expired cache should be stale")
+		}
+		revalidated := make(chan *http.Request, 1)
+		fn := CacheFetcher(CacheFetcherConfig{
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				revalidated <- req
+			}),
+		}, client)
+		r := httptest.NewRequest(http.MethodGet, "http://aslant.site/stale", nil)
+		r.Header.Set("X-Token", "abc")
+		c := pike.NewContext(r)
+		c.Status = status
+		c.Identity = identity
+		err := fn(c, func() error {
+			// 后续的中间件会修改请求（如rewrite与设置header）
+			c.Request.URL.Path = "/rewrite"
+			c.Request.Header.Set("X-Token", "def")
+			return nil
+		})
+		if err != nil || c.Resp == nil {
+			t.Fatalf("stale cache fetch fail, %v", err)
+		}
+		req := <-revalidated
+		if !isRevalidateRequest(req) {
+			t.Fatalf("the stale cache should be revalidated in background")
+		}
+		if req.URL.Path != "/stale" || req.Header.Get("X-Token") != "abc" {
+			t.Fatalf("the revalidate request should not be modified by the following middlewares")
+		}
+	})
+
 	t.Run("fetch with no status", func(t *testing.T) {
 		fn := CacheFetcher(config, client)
 		c := pike.NewContext(nil)
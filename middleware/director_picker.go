@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"net/http"
+
 	"github.com/vicanso/pike/cache"
 	"github.com/vicanso/pike/pike"
 )
@@ -11,32 +13,34 @@ type (
 	}
 )
 
+// pickDirector 获取第一个匹配请求的director
+func pickDirector(directors pike.Directors, req *http.Request) *pike.Director {
+	host := req.Host
+	uri := req.RequestURI
+	for _, d := range directors {
+		if d.Match(host, uri) {
+			return d
+		}
+	}
+	return nil
+}
+
 // DirectorPicker 根据请求的参数获取相应的director
 // 判断director是否符合是顺序查询，因此需要将directors先根据优先级排好序
 func DirectorPicker(config DirectorPickerConfig, directors pike.Directors) pike.Middleware {
 	return func(c *pike.Context, next pike.Next) error {
 		done := c.ServerTiming.Start(pike.ServerTimingDirectorPicker)
 		// 如果缓存数据，不需要获取director
-		if c.Status == cache.Cacheable {
+		if c.Status == cache.Cacheable || c.Status == cache.Stale {
 			done()
 			return next()
 		}
-		req := c.Request
-		host := req.Host
-		uri := req.RequestURI
-		found := false
-
-		for _, d := range directors {
-			if d.Match(host, uri) {
-				c.Director = d
-				found = true
-				break
-			}
-		}
-		if !found {
+		d := pickDirector(directors, c.Request)
+		if d == nil {
 			done()
 			return ErrDirectorNotFound
 		}
+		c.Director = d
 		done()
 		return next()
 	}
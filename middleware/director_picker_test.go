@@ -31,6 +31,7 @@ func TestDirectorPicker(t *testing.T) {
 	directors = append(directors, d)
 	for _, d := range directors {
 		d.RefreshPriority()
+		d.GenHostRegexp()
 	}
 	sort.Sort(directors)
 	config := DirectorPickerConfig{}
@@ -1,9 +1,14 @@
 package middleware
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/vicanso/pike/cache"
@@ -95,6 +100,103 @@ func save(client *cache.Client, identity []byte, resp *cache.Response, compressi
 	return
 }
 
+// getVaryFields 获取响应头中Vary的字段（Accept-Encoding由pike根据压缩处理，因此忽略）
+func getVaryFields(header http.Header) (fields []string) {
+	for _, value := range header[pike.HeaderVary] {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" {
+				return []string{field}
+			}
+			field = http.CanonicalHeaderKey(field)
+			if field == "" || field == pike.HeaderAcceptEncoding {
+				continue
+			}
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return
+}
+
+// getCacheIdentity 根据响应的Vary获取缓存的标记，如果返回nil表示Vary为*，不可缓存，
+// 有Vary时在cr中记录基础标记与Vary字段（与缓存数据一起保存）
+func getCacheIdentity(client *cache.Client, c *pike.Context, cr *cache.Response) []byte {
+	identity := c.Identity
+	baseIdentity := c.BaseIdentity
+	if len(baseIdentity) == 0 {
+		return identity
+	}
+	fields := getVaryFields(cr.Header)
+	if len(fields) != 0 && fields[0] == "*" {
+		client.SetVary(baseIdentity, nil)
+		return nil
+	}
+	if len(fields) == 0 {
+		return identity
+	}
+	client.SetVary(baseIdentity, fields)
+	cr.BaseKey = baseIdentity
+	cr.Vary = fields
+	return genVaryIdentity(baseIdentity, fields, c.Request.Header)
+}
+
+// setStreamBody 根据Content-Encoding设置直接转发的响应数据，不支持的编码返回false
+func setStreamBody(cr *cache.Response, body []byte) bool {
+	switch cr.Header.Get(pike.HeaderContentEncoding) {
+	case "":
+		cr.Body = body
+	case cache.GzipEncoding:
+		cr.GzipBody = body
+	case cache.BrEncoding:
+		cr.BrBody = body
+	default:
+		return false
+	}
+	return true
+}
+
+// dispatchStream 将backend的响应数据直接转发至客户端，
+// 如果doSave不为空（可缓存），则在数据读取完成后保存
+func dispatchStream(c *pike.Context, cr *cache.Response, doSave func()) error {
+	stream := c.Stream
+	var reader io.Reader = stream
+	var buffer *bytes.Buffer
+	if doSave != nil {
+		buffer = new(bytes.Buffer)
+		reader = io.TeeReader(stream, buffer)
+	}
+	finish := func(err error) {
+		if doSave == nil {
+			return
+		}
+		// 数据读取出错则不缓存（设置为hit for pass）
+		if err != nil || !setStreamBody(cr, buffer.Bytes()) {
+			cr.TTL = 0
+		}
+		go doSave()
+	}
+	resp := c.Response
+	// 304 的处理，可缓存的数据在后台读取完成后保存
+	if c.Fresh {
+		resp.WriteHeader(http.StatusNotModified)
+		if doSave != nil {
+			// 由后台读取，避免请求结束时被关闭
+			c.Stream = nil
+			go func() {
+				_, err := io.Copy(ioutil.Discard, reader)
+				stream.Close()
+				finish(err)
+			}()
+		}
+		return nil
+	}
+	resp.WriteHeader(int(cr.StatusCode))
+	err := resp.Stream(c.ResponseWriter, reader)
+	finish(err)
+	return err
+}
+
 func shouldCompress(compressTypes []string, contentType string) (compressible bool) {
 	for _, v := range compressTypes {
 		reg := regexp.MustCompile(v)
@@ -138,7 +240,7 @@ func Dispatcher(config DispatcherConfig, client *cache.Client) pike.Middleware {
 
 		compressible := shouldCompress(compressTypes, header.Get(pike.HeaderContentType))
 
-		if status == cache.Cacheable {
+		if status == cache.Cacheable || status == cache.Stale {
 			// 如果数据是读取缓存，有需要设置Age
 			age := uint32(time.Now().Unix()) - cr.CreatedAt
 			respHeader.Set(pike.HeaderAge, strconv.Itoa(int(age)))
@@ -149,18 +251,52 @@ func Dispatcher(config DispatcherConfig, client *cache.Client) pike.Middleware {
 		statusCode := int(cr.StatusCode)
 
 		// pass的都是不可能缓存
-		// 可缓存的处理继续后续缓存流程
-		if status != cache.Cacheable && status != cache.Pass {
+		// 可缓存的处理继续后续缓存流程（stale为已缓存的数据）
+		var streamSave func()
+		if status != cache.Cacheable && status != cache.Pass && status != cache.Stale {
 			identity := c.Identity
-			go func() {
-				if cr.TTL == 0 {
+			var cacheIdentity []byte
+			if cr.TTL != 0 {
+				cacheIdentity = getCacheIdentity(client, c, cr)
+			}
+			doSave := func() {
+				if cr.TTL == 0 || cacheIdentity == nil {
+					// Vary: * 的响应也设置为hit for pass
 					if status != cache.HitForPass {
 						client.HitForPass(identity, HitForPassTTL)
 					}
-				} else {
+					return
+				}
+				if bytes.Equal(cacheIdentity, identity) {
 					save(client, identity, cr, compressible)
+					return
 				}
-			}()
+				// 首次获取到Vary，当前标记的等待请求设置为hit for pass，
+				// 数据保存至对应版本的标记（如果该标记无其它请求在获取中）
+				if status != cache.HitForPass {
+					client.HitForPass(identity, HitForPassTTL)
+				}
+				varyStatus, ch := client.GetRequestStatus(cacheIdentity)
+				if ch != nil {
+					// 已有请求在获取该版本的数据，等待完成即可
+					<-ch
+					return
+				}
+				if varyStatus == cache.Fetching {
+					save(client, cacheIdentity, cr, compressible)
+				}
+			}
+			// 直接转发并且可缓存的数据，需要读取完成后才保存
+			if c.Stream != nil && cr.TTL != 0 && cacheIdentity != nil {
+				streamSave = doSave
+			} else {
+				go doSave()
+			}
+		}
+
+		if c.Stream != nil {
+			setSeverTiming()
+			return dispatchStream(c, cr, streamSave)
 		}
 
 		// 304 的处理
@@ -2,8 +2,10 @@ package middleware
 
 import (
 	"bytes"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -29,6 +31,27 @@ func TestShouldCompress(t *testing.T) {
 	})
 }
 
+func TestGetVaryFields(t *testing.T) {
+	t.Run("vary fields", func(t *testing.T) {
+		header := http.Header{}
+		header.Add(pike.HeaderVary, "accept-encoding, X-Device")
+		header.Add(pike.HeaderVary, "Accept-Language")
+		fields := getVaryFields(header)
+		if strings.Join(fields, ",") != "Accept-Language,X-Device" {
+			t.Fatalf("get vary fields fail")
+		}
+	})
+
+	t.Run("vary all", func(t *testing.T) {
+		header := http.Header{}
+		header.Set(pike.HeaderVary, "Accept-Language, *")
+		fields := getVaryFields(header)
+		if len(fields) != 1 || fields[0] != "*" {
+			t.Fatalf("vary * should return only *")
+		}
+	})
+}
+
 func TestSave(t *testing.T) {
 	client := &cache.Client{
 		Path: "/tmp/test.cache",
@@ -309,4 +332,118 @@ func TestDispatcher(t *testing.T) {
 			t.Fatalf("the response body should be ABCD")
 		}
 	})
+	t.Run("dispatch vary response", func(t *testing.T) {
+		identity := []byte("GET aslant.site /vary")
+		client.GetRequestStatus(identity)
+		fn := Dispatcher(conf, client)
+		req := httptest.NewRequest(http.MethodGet, "/vary", nil)
+		req.Header.Set("Accept-Language", "zh")
+		c := pike.NewContext(req)
+		c.Identity = identity
+		c.BaseIdentity = identity
+		c.Status = cache.Fetching
+		header := http.Header{}
+		header.Set(pike.HeaderVary, "Accept-Language")
+		c.Resp = &cache.Response{
+			CreatedAt:  uint32(time.Now().Unix()),
+			TTL:        300,
+			StatusCode: 200,
+			Header:     header,
+			Body:       []byte("ABCD"),
+		}
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("dispatch vary response fail, %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		fields := client.GetVary(identity)
+		if len(fields) != 1 || fields[0] != "Accept-Language" {
+			t.Fatalf("the vary fields should be saved")
+		}
+		varyIdentity := []byte("GET aslant.site /vary Accept-Language=zh")
+		defer client.Remove(varyIdentity)
+		resp, err := client.GetResponse(varyIdentity)
+		if err != nil || resp == nil {
+			t.Fatalf("the response should be saved by vary identity, %v", err)
+		}
+		// 保存基础标记与Vary字段，重启后用于恢复
+		if !bytes.Equal(resp.BaseKey, identity) || len(resp.Vary) != 1 || resp.Vary[0] != "Accept-Language" {
+			t.Fatalf("the base identity and vary should be saved with the response")
+		}
+		status, _ := client.GetRequestStatus(identity)
+		if status != cache.HitForPass {
+			t.Fatalf("the base identity should be hit for pass")
+		}
+	})
+
+	t.Run("dispatch vary all response", func(t *testing.T) {
+		identity := []byte("GET aslant.site /vary-all")
+		client.GetRequestStatus(identity)
+		fn := Dispatcher(conf, client)
+		req := httptest.NewRequest(http.MethodGet, "/vary-all", nil)
+		c := pike.NewContext(req)
+		c.Identity = identity
+		c.BaseIdentity = identity
+		c.Status = cache.Fetching
+		header := http.Header{}
+		header.Set(pike.HeaderVary, "*")
+		c.Resp = &cache.Response{
+			CreatedAt:  uint32(time.Now().Unix()),
+			TTL:        300,
+			StatusCode: 200,
+			Header:     header,
+			Body:       []byte("ABCD"),
+		}
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("dispatch vary all response fail, %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+		status, _ := client.GetRequestStatus(identity)
+		if status != cache.HitForPass {
+			t.Fatalf("the response of vary * should be hit for pass")
+		}
+	})
+	t.Run("dispatch stream response", func(t *testing.T) {
+		identity := []byte("GET aslant.site /stream")
+		client.GetRequestStatus(identity)
+		defer client.Remove(identity)
+		fn := Dispatcher(conf, client)
+		req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+		c := pike.NewContext(req)
+		w := httptest.NewRecorder()
+		c.ResponseWriter = w
+		c.Identity = identity
+		c.Status = cache.Fetching
+		data := strings.Repeat("ABCD", 1024)
+		c.Resp = &cache.Response{
+			CreatedAt:  uint32(time.Now().Unix()),
+			TTL:        300,
+			StatusCode: 200,
+			Header:     make(http.Header),
+		}
+		c.Stream = ioutil.NopCloser(strings.NewReader(data))
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("dispatch stream response fail, %v", err)
+		}
+		if !c.Response.Committed || w.Code != 200 || w.Body.String() != data {
+			t.Fatalf("the stream response should be written to response writer")
+		}
+		time.Sleep(100 * time.Millisecond)
+		resp, err := client.GetResponse(identity)
+		if err != nil || resp == nil {
+			t.Fatalf("the stream response should be saved, %v", err)
+		}
+		body, _ := resp.GetBody("")
+		if string(body) != data {
+			t.Fatalf("the saved data of stream response is wrong")
+		}
+	})
 }
@@ -6,7 +6,6 @@ import (
 
 	"github.com/vicanso/pike/cache"
 	"github.com/vicanso/pike/pike"
-	"github.com/vicanso/pike/util"
 )
 
 // echo 默认的http error处理，增加对feching状态的设置
@@ -14,9 +13,10 @@ import (
 // CreateErrorHandler  创建异常处理函数
 func CreateErrorHandler(client *cache.Client) pike.ErrorHandler {
 	return func(err error, c *pike.Context) {
-		// 如果出错的请求，都设置为hit for pass
-		key := util.GetIdentity(c.Request)
-		client.HitForPass(key, HitForPassTTL)
+		// 如果fetching中的请求出错，设置为hit for pass，避免相同的请求一直等待
+		if c.Status == cache.Fetching && c.Identity != nil {
+			client.HitForPass(c.Identity, HitForPassTTL)
+		}
 		if c.Response.Committed {
 			return
 		}
@@ -37,6 +37,12 @@ func HeaderSetter(config HeaderSetterConfig) pike.Middleware {
 				h.Add(k, v)
 			}
 		}
+		// sticky策略设置记录backend的cookie（只设置给客户端，不保存至缓存）
+		if c.Director != nil {
+			if cookie := c.Director.GetStickyCookie(c, c.Backend); cookie != nil {
+				h.Add(pike.HeaderSetCookie, cookie.String())
+			}
+		}
 		done()
 		return next()
 	}
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/vicanso/pike/cache"
@@ -49,4 +51,28 @@ func TestHeaderSetter(t *testing.T) {
 			t.Fatalf("header token field should be ABCD")
 		}
 	})
+
+	t.Run("set sticky cookie", func(t *testing.T) {
+		fn := HeaderSetter(headerSetterConfig)
+		c := pike.NewContext(httptest.NewRequest(http.MethodGet, "/", nil))
+		c.Director = &pike.Director{
+			Policy: "sticky",
+		}
+		c.Backend = "http://127.0.0.1:5001"
+		c.Resp = &cache.Response{
+			Header: http.Header{},
+		}
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("set header fail, %v", err)
+		}
+		if !strings.HasPrefix(c.Response.Header().Get(pike.HeaderSetCookie), "pike_sticky=") {
+			t.Fatalf("should set the sticky cookie")
+		}
+		if len(c.Resp.Header) != 0 {
+			t.Fatalf("the sticky cookie should not be set to the cache response")
+		}
+	})
 }
@@ -2,6 +2,8 @@ package middleware
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/vicanso/pike/cache"
 	"github.com/vicanso/pike/pike"
@@ -12,9 +14,34 @@ type (
 	// IdentifierConfig 定义配置
 	IdentifierConfig struct {
 		Format string
+		// WaitTimeout 等待相同请求获取数据的超时，默认为30s
+		WaitTimeout time.Duration
 	}
 )
 
+const (
+	defaultWaitTimeout = 30 * time.Second
+)
+
+// genVaryIdentity 根据Vary的字段与请求头生成区分不同版本的标记
+func genVaryIdentity(key []byte, fields []string, header http.Header) []byte {
+	size := len(key)
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i] = strings.Join(header[field], ",")
+		size += len(field) + len(values[i]) + 2
+	}
+	buffer := make([]byte, 0, size)
+	buffer = append(buffer, key...)
+	for i, field := range fields {
+		buffer = append(buffer, ' ')
+		buffer = append(buffer, field...)
+		buffer = append(buffer, '=')
+		buffer = append(buffer, values[i]...)
+	}
+	return buffer
+}
+
 // Identifier 对请求的参数校验，生成各类状态值
 /*
 - 判断请求状态，生成status
@@ -25,6 +52,10 @@ func Identifier(config IdentifierConfig, client *cache.Client) pike.Middleware {
 	if config.Format != "" {
 		fn = util.GenerateGetIdentity(config.Format)
 	}
+	waitTimeout := defaultWaitTimeout
+	if config.WaitTimeout > 0 {
+		waitTimeout = config.WaitTimeout
+	}
 	return func(c *pike.Context, next pike.Next) error {
 		serverTiming := c.ServerTiming
 		done := serverTiming.Start(pike.ServerTimingIdentifier)
@@ -36,14 +67,38 @@ func Identifier(config IdentifierConfig, client *cache.Client) pike.Middleware {
 			done()
 			return next()
 		}
-		key := fn(req)
-		status, ch := client.GetRequestStatus(key)
-		// TODO是否应该增加超时机制（proxy中已有超时机制，应该不会有其它流程会卡，因此暂认为无需处理）
-		if ch != nil {
-			status = <-ch
+		baseKey := fn(req)
+		key := baseKey
+		// 如果响应有Vary，则根据请求头生成对应版本的标记
+		if fields := client.GetVary(baseKey); len(fields) != 0 {
+			key = genVaryIdentity(baseKey, fields, req.Header)
+		}
+		c.BaseIdentity = baseKey
+		// 后台更新缓存的请求，状态已设置为fetching
+		if isRevalidateRequest(req) {
+			c.Status = cache.Fetching
+			c.Identity = key
+			done()
+			return next()
 		}
+		status, ch := client.GetRequestStatus(key)
 		c.Status = status
 		c.Identity = key
+		// 等待相同的请求获取数据，超时或者客户端断开则返回出错
+		if ch != nil {
+			timer := time.NewTimer(waitTimeout)
+			defer timer.Stop()
+			select {
+			case status = <-ch:
+				c.Status = status
+			case <-timer.C:
+				done()
+				return ErrWaitTimeout
+			case <-req.Context().Done():
+				done()
+				return ErrClientClosed
+			}
+		}
 		done()
 		return next()
 	}
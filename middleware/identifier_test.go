@@ -73,4 +73,49 @@ func TestIdentifier(t *testing.T) {
 			t.Fatalf("the wait for status should be hit for pass")
 		}
 	})
+	t.Run("vary identity", func(t *testing.T) {
+		req := &http.Request{
+			Method:     http.MethodGet,
+			Host:       "127.0.0.1",
+			RequestURI: "/vary",
+			Header:     http.Header{},
+		}
+		req.Header.Set("Accept-Language", "en")
+		baseIdentity := []byte("GET 127.0.0.1 /vary")
+		client.SetVary(baseIdentity, []string{"Accept-Language", "X-Device"})
+		defer client.SetVary(baseIdentity, nil)
+		c := pike.NewContext(req)
+		err = fn(c, func() error {
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("identifier with vary fail, %v", err)
+		}
+		if string(c.BaseIdentity) != string(baseIdentity) ||
+			string(c.Identity) != "GET 127.0.0.1 /vary Accept-Language=en X-Device=" {
+			t.Fatalf("the vary identity is wrong")
+		}
+	})
+	t.Run("waiting timeout", func(t *testing.T) {
+		fn := Identifier(IdentifierConfig{
+			Format:      "method host uri",
+			WaitTimeout: 10 * time.Millisecond,
+		}, client)
+		req := &http.Request{
+			Method:     http.MethodGet,
+			Host:       "127.0.0.1",
+			RequestURI: "/wait-timeout",
+		}
+		key := []byte("GET 127.0.0.1 /wait-timeout")
+		client.GetRequestStatus(key)
+		c := pike.NewContext(req)
+		err = fn(c, func() error {
+			return nil
+		})
+		if err != ErrWaitTimeout {
+			t.Fatalf("waiting request should return error after timeout")
+		}
+		// 等待超时的请求不再读取channel，更新状态也不会阻塞
+		client.HitForPass(key, 60)
+	})
 }
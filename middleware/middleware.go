@@ -29,6 +29,16 @@ var (
 	ErrNoBackendAvaliable = pike.NewHTTPError(http.StatusServiceUnavailable, "no backend avaliable")
 	// ErrGatewayTimeout 网关超时
 	ErrGatewayTimeout = pike.NewHTTPError(http.StatusGatewayTimeout, "gateway timeout")
+	// ErrBadGateway 连接backend失败
+	ErrBadGateway = pike.NewHTTPError(http.StatusBadGateway, "bad gateway")
+	// ErrHijackNotSupport 不支持hijack，无法转发upgrade请求
+	ErrHijackNotSupport = pike.NewHTTPError(http.StatusNotImplemented, "hijack not support")
+	// ErrWaitTimeout 等待相同请求获取数据超时
+	ErrWaitTimeout = pike.NewHTTPError(http.StatusGatewayTimeout, "wait for fetching timeout")
+	// ErrClientClosed 客户端已断开连接
+	ErrClientClosed = pike.NewHTTPError(499, "client closed request")
+	// ErrResponseAborted backend的响应数据未完整获取
+	ErrResponseAborted = pike.NewHTTPError(http.StatusBadGateway, "the response of backend is aborted")
 	// ErrTooManyRequest 太多的请求正在处理中
 	ErrTooManyRequest = pike.NewHTTPError(http.StatusTooManyRequests, "too many request is handling")
 )
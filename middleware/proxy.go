@@ -1,19 +1,23 @@
 package middleware
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"regexp"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 	"unsafe"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/vicanso/pike/pike"
 
 	"github.com/vicanso/pike/cache"
@@ -39,12 +43,42 @@ type (
 		rewriteRegexp map[*regexp.Regexp]string
 		// Timeout proxy的连接超时
 		Timeout time.Duration
+
+		// StreamPass pass的请求是否直接转发响应数据（不缓存至内存）
+		StreamPass bool
+		// StreamThreshold 响应数据的Content-Length大于此值时直接转发，0表示不启用
+		StreamThreshold int64
+		// StreamCache 直接转发的响应如果可缓存，是否同时保存至缓存
+		StreamCache bool
 	}
 	// ProxyTarget defines the upstream target.
 	ProxyTarget struct {
 		Name string
 		URL  *url.URL
 	}
+	// streamWriter proxy使用的ResponseWriter，根据响应头判断是否直接转发数据，
+	// 如果不直接转发，则数据写入pike.Response
+	streamWriter struct {
+		*pike.Response
+		shouldStream func(code int, header http.Header) bool
+		// 判断为直接转发时关闭
+		streamReady chan struct{}
+		streaming   bool
+		reader      *io.PipeReader
+		writer      *io.PipeWriter
+		// err 连接backend出错（由ReverseProxy的ErrorHandler设置）
+		err error
+		// 直接转发时backend无数据传输的超时，超时则调用onIdle中断转发
+		idleTimeout time.Duration
+		idleTimer   *time.Timer
+		onIdle      func()
+	}
+	// proxyResult proxy goroutine的结果
+	proxyResult struct {
+		completed bool
+		// panicked proxy中的panic（非http.ErrAbortHandler），需要在调用者的goroutine中处理
+		panicked error
+	}
 )
 
 const (
@@ -52,16 +86,92 @@ const (
 )
 
 var (
-	noCacheReg      = regexp.MustCompile(`no-cache|no-store|private`)
-	sMaxAgeReg      = regexp.MustCompile(`s-maxage=(\d+)`)
-	maxAgeReg       = regexp.MustCompile(`max-age=(\d+)`)
-	proxyTargetPool = sync.Pool{
+	// 默认需要重试的响应状态码
+	defaultRetryStatus = []int{
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+	}
+	noCacheReg = regexp.MustCompile(`no-cache|no-store|private`)
+	sMaxAgeReg = regexp.MustCompile(`s-maxage=(\d+)`)
+	maxAgeReg  = regexp.MustCompile(`max-age=(\d+)`)
+	// stale-while-revalidate与stale-if-error（RFC 5861）
+	staleWhileRevalidateReg = regexp.MustCompile(`stale-while-revalidate=(\d+)`)
+	staleIfErrorReg         = regexp.MustCompile(`stale-if-error=(\d+)`)
+	proxyTargetPool         = sync.Pool{
 		New: func() interface{} {
 			return &ProxyTarget{}
 		},
 	}
 )
 
+func newStreamWriter(shouldStream func(code int, header http.Header) bool) *streamWriter {
+	w := &streamWriter{
+		Response:     pike.NewResponse(),
+		shouldStream: shouldStream,
+		streamReady:  make(chan struct{}),
+	}
+	if shouldStream != nil {
+		w.reader, w.writer = io.Pipe()
+	}
+	return w
+}
+
+// WriteHeader 写入响应状态码，并判断是否直接转发数据
+func (w *streamWriter) WriteHeader(code int) {
+	w.Response.WriteHeader(code)
+	if w.shouldStream == nil || !w.shouldStream(code, w.Header()) {
+		return
+	}
+	w.streaming = true
+	if w.onIdle != nil {
+		w.idleTimer = time.AfterFunc(w.idleTimeout, w.onIdle)
+	}
+	close(w.streamReady)
+}
+
+// Write 写入响应数据，直接转发时写入pipe，等待客户端读取
+func (w *streamWriter) Write(b []byte) (int, error) {
+	if w.streaming {
+		if w.idleTimer == nil {
+			return w.writer.Write(b)
+		}
+		// 写入pipe时等待的是客户端读取，不计入backend的超时
+		w.idleTimer.Stop()
+		n, err := w.writer.Write(b)
+		w.idleTimer.Reset(w.idleTimeout)
+		return n, err
+	}
+	return w.Response.Write(b)
+}
+
+// Flush 直接转发的数据写入pipe时已转发，无需处理
+func (w *streamWriter) Flush() {
+}
+
+// done proxy完成时调用，结束直接转发的数据
+func (w *streamWriter) done() {
+	if w.idleTimer != nil {
+		w.idleTimer.Stop()
+	}
+	if w.writer != nil {
+		w.writer.Close()
+	}
+}
+
+// fail proxy出错时调用，直接转发的数据读取时返回该出错
+func (w *streamWriter) fail(err error) {
+	if w.writer != nil {
+		w.writer.CloseWithError(err)
+	}
+}
+
+// abort 不再读取直接转发的数据（超时等），避免proxy的写入一直阻塞
+func (w *streamWriter) abort() {
+	if w.reader != nil {
+		w.reader.CloseWithError(io.ErrClosedPipe)
+	}
+}
+
 // genETag 获取数据对应的ETag
 func genETag(buf []byte) string {
 	size := len(buf)
@@ -103,16 +213,133 @@ func proxyHTTP(t *ProxyTarget, transport *http.Transport) http.Handler {
 	if transport != nil {
 		p.Transport = transport
 	}
+	// 记录连接出错，用于判断是否需要重试
+	p.ErrorHandler = func(w http.ResponseWriter, req *http.Request, err error) {
+		if sw, ok := w.(*streamWriter); ok {
+			sw.err = err
+		}
+		w.WriteHeader(http.StatusBadGateway)
+	}
 	return p
 }
 
+// doProxy 将请求转发至backend，返回是否超时与是否完成（未被中断），
+// 直接转发时响应数据在返回之后才转发完成，backend无数据传输超过timeout则中断
+func doProxy(director *pike.Director, backend string, targetURL *url.URL, req *http.Request, writer *streamWriter, timeout time.Duration) (timedOut, completed bool) {
+	// 直接转发时proxy在返回之后才完成，因此使用带缓冲的channel，
+	// 超时时goroutine也可以正常结束
+	proxyDone := make(chan proxyResult, 1)
+	// backend的请求在超时或者客户端断开时取消
+	ctx, cancel := context.WithCancel(req.Context())
+	proxyReq := req.WithContext(ctx)
+	writer.idleTimeout = timeout
+	writer.onIdle = func() {
+		cancel()
+		writer.abort()
+	}
+	// 记录backend正在处理的请求数与响应时间（用于leastConn与ewma）
+	director.BeginRequest(backend)
+	startedAt := time.Now()
+
+	go func() {
+		aborted := true
+		defer func() {
+			result := proxyResult{}
+			// 转发数据出错（如客户端中断）时ReverseProxy会panic(http.ErrAbortHandler)，
+			// 其它的panic不能在此goroutine中抛出（会导致程序退出），转至调用者处理
+			if r := recover(); r != nil && r != http.ErrAbortHandler {
+				result.panicked = fmt.Errorf("proxy panic: %v\n%s", r, debug.Stack())
+				writer.fail(result.panicked)
+			}
+			writer.done()
+			cancel()
+			director.EndRequest(backend, time.Since(startedAt))
+			result.completed = !aborted
+			proxyDone <- result
+		}()
+		// 在proxy http之后则立即release
+		tgt := proxyTargetPool.Get().(*ProxyTarget)
+		tgt.Name = director.Name
+		tgt.URL = targetURL
+		proxyHTTP(tgt, director.Transport).ServeHTTP(writer, proxyReq)
+		proxyTargetPool.Put(tgt)
+		aborted = false
+	}()
+	completed = true
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case result := <-proxyDone:
+		if result.panicked != nil {
+			panic(result.panicked)
+		}
+		completed = result.completed
+		return
+	case <-writer.streamReady:
+	case <-timer.C:
+		timedOut = true
+		cancel()
+		writer.abort()
+	}
+	// 不再等待proxy完成，之后出现的panic只记录（直接转发的数据读取时返回出错）
+	go func() {
+		if result := <-proxyDone; result.panicked != nil {
+			log.Error(result.panicked)
+		}
+	}()
+	return
+}
+
+// reportBackend 记录backend的请求结果（用于熔断）
+func reportBackend(director *pike.Director, backend string, writer *streamWriter, timedOut, completed bool) {
+	cb := director.CircuitBreaker
+	if cb == nil {
+		return
+	}
+	// 超时后不能再读取writer的状态（proxy的goroutine仍可能写入）
+	failure := timedOut || !completed || writer.err != nil
+	if !failure && !writer.streaming {
+		failure = cb.IsFailure(writer.Status())
+	}
+	director.Report(backend, !failure)
+}
+
+// shouldRetry 根据重试配置判断请求是否需要重试
+func shouldRetry(retry *pike.Retry, writer *streamWriter, timedOut, completed bool) bool {
+	if retry == nil {
+		return false
+	}
+	// 超时后proxy的goroutine仍可能写入writer，因此需要先判断，不能再读取writer的状态
+	if timedOut || !completed {
+		return retry.Error
+	}
+	// 已开始直接转发的响应无法重试
+	if writer.streaming {
+		return false
+	}
+	if writer.err != nil {
+		return retry.Error
+	}
+	statusList := retry.Status
+	if len(statusList) == 0 {
+		statusList = defaultRetryStatus
+	}
+	status := writer.Status()
+	for _, v := range statusList {
+		if v == status {
+			return true
+		}
+	}
+	return false
+}
+
 // byteSliceToString converts a []byte to string without a heap allocation.
 func byteSliceToString(b []byte) string {
 	return *(*string)(unsafe.Pointer(&b))
 }
 
 // 根据Cache-Control的信息，获取s-maxage或者max-age的值
-func getCacheAge(header http.Header) uint16 {
+func getCacheAge(header http.Header) uint32 {
 	// 如果有设置cookie，则为不可缓存
 	if len(header.Get(pike.HeaderSetCookie)) != 0 {
 		return 0
@@ -137,8 +364,7 @@ func getCacheAge(header http.Header) uint16 {
 	// 优先从s-maxage中获取
 	result := sMaxAgeReg.FindSubmatch(cacheControl)
 	if len(result) == 2 {
-		maxAge, _ := strconv.Atoi(byteSliceToString(result[1]))
-		return uint16(maxAge)
+		return parseCacheAge(result[1])
 	}
 
 	// 从max-age中获取缓存时间
@@ -146,17 +372,88 @@ func getCacheAge(header http.Header) uint16 {
 	if len(result) != 2 {
 		return 0
 	}
-	maxAge, _ := strconv.Atoi(byteSliceToString(result[1]))
-	return uint16(maxAge)
+	return parseCacheAge(result[1])
+}
+
+// 根据Cache-Control的信息，获取stale-while-revalidate与stale-if-error的值
+func getStaleAge(header http.Header) (staleWhileRevalidate, staleIfError uint32) {
+	cacheControl := []byte(header.Get(pike.HeaderCacheControl))
+	if len(cacheControl) == 0 {
+		return
+	}
+	result := staleWhileRevalidateReg.FindSubmatch(cacheControl)
+	if len(result) == 2 {
+		staleWhileRevalidate = parseCacheAge(result[1])
+	}
+	result = staleIfErrorReg.FindSubmatch(cacheControl)
+	if len(result) == 2 {
+		staleIfError = parseCacheAge(result[1])
+	}
+	return
+}
+
+// parseCacheAge 转换缓存时间，超出uint32的则使用最大值
+func parseCacheAge(buf []byte) uint32 {
+	// 如果超出范围，ParseUint返回的是最大值
+	maxAge, _ := strconv.ParseUint(byteSliceToString(buf), 10, 32)
+	return uint32(maxAge)
+}
+
+// useStaleIfError 出错时如果有可用的过期缓存数据（stale-if-error），则使用过期数据
+func useStaleIfError(c *pike.Context, client *cache.Client) bool {
+	if client == nil || c.Identity == nil || c.Status == cache.Pass {
+		return false
+	}
+	resp := client.GetStaleIfError(c.Identity)
+	if resp == nil {
+		return false
+	}
+	c.Resp = resp
+	c.Status = cache.Stale
+	return true
+}
+
+// getValidatedResponse 获取过期的缓存数据（需要有ETag或Last-Modified），用于向backend发送条件请求
+func getValidatedResponse(c *pike.Context, client *cache.Client) *cache.Response {
+	if client == nil || c.Identity == nil || c.Status != cache.Fetching {
+		return nil
+	}
+	resp, err := client.GetResponse(c.Identity)
+	if err != nil || resp == nil {
+		return nil
+	}
+	header := resp.Header
+	if len(header.Get(pike.HeaderETag)) == 0 && len(header.Get(pike.HeaderLastModified)) == 0 {
+		return nil
+	}
+	return resp
+}
+
+// refreshResponse 根据backend返回304的响应头更新缓存数据的有效期
+func refreshResponse(resp *cache.Response, header http.Header) {
+	for k, v := range header {
+		if k == pike.HeaderContentLength || k == pike.HeaderContentEncoding {
+			continue
+		}
+		resp.Header[k] = v
+	}
+	resp.CreatedAt = uint32(time.Now().Unix())
+	resp.TTL = getCacheAge(resp.Header)
+	resp.StaleWhileRevalidate = 0
+	resp.StaleIfError = 0
+	if resp.TTL != 0 {
+		resp.StaleWhileRevalidate, resp.StaleIfError = getStaleAge(resp.Header)
+	}
 }
 
 // Proxy returns a Proxy middleware with config.
-func Proxy(config ProxyConfig) pike.Middleware {
+func Proxy(config ProxyConfig, client *cache.Client) pike.Middleware {
 	config.rewriteRegexp = util.GetRewriteRegexp(config.Rewrites)
 	timeout := defaultTimeout
 	if config.Timeout > 0 {
 		timeout = config.Timeout
 	}
+	streamEnabled := config.StreamPass || config.StreamThreshold > 0
 
 	return func(c *pike.Context, next pike.Next) error {
 		done := c.ServerTiming.Start(pike.ServerTimingProxy)
@@ -175,6 +472,9 @@ func Proxy(config ProxyConfig) pike.Middleware {
 		backend := director.Select(c)
 		if len(backend) == 0 {
 			done()
+			if useStaleIfError(c, client) {
+				return next()
+			}
 			return ErrNoBackendAvaliable
 		}
 
@@ -201,7 +501,24 @@ func Proxy(config ProxyConfig) pike.Middleware {
 		}
 
 		// Proxy
-		writer := pike.NewResponse()
+		var shouldStream func(code int, header http.Header) bool
+		if streamEnabled {
+			status := c.Status
+			shouldStream = func(code int, header http.Header) bool {
+				// 出错的响应不直接转发（需要判断是否重试或使用stale-if-error）
+				if code >= http.StatusInternalServerError {
+					return false
+				}
+				if status == cache.Pass {
+					return config.StreamPass
+				}
+				if config.StreamThreshold <= 0 {
+					return false
+				}
+				length, err := strconv.ParseInt(header.Get(pike.HeaderContentLength), 10, 64)
+				return err == nil && length > config.StreamThreshold
+			}
+		}
 
 		// proxy时为了避免304的出现，因此调用时临时删除header
 		ifModifiedSince := reqHeader.Get(pike.HeaderIfModifiedSince)
@@ -212,30 +529,91 @@ func Proxy(config ProxyConfig) pike.Middleware {
 		if len(ifNoneMatch) != 0 {
 			reqHeader.Del(pike.HeaderIfNoneMatch)
 		}
-		proxyDone := make(chan bool)
+		// 如果有过期的缓存数据，则根据其ETag与Last-Modified向backend做条件请求
+		validated := getValidatedResponse(c, client)
+		if validated != nil {
+			if eTag := validated.Header.Get(pike.HeaderETag); len(eTag) != 0 {
+				reqHeader.Set(pike.HeaderIfNoneMatch, eTag)
+			}
+			if lastModified := validated.Header.Get(pike.HeaderLastModified); len(lastModified) != 0 {
+				reqHeader.Set(pike.HeaderIfModifiedSince, lastModified)
+			}
+		}
 
-		go func() {
-			// 在proxy http之后则立即release
-			tgt := proxyTargetPool.Get().(*ProxyTarget)
-			tgt.Name = director.Name
-			tgt.URL = targetURL
-			proxyHTTP(tgt, director.Transport).ServeHTTP(writer, req)
-			proxyTargetPool.Put(tgt)
-			proxyDone <- true
-		}()
-		select {
-		case <-proxyDone:
-			close(proxyDone)
-		case <-time.After(timeout):
-			return ErrGatewayTimeout
+		// 重试只针对GET与HEAD请求，使用未尝试过的backend
+		retry := director.Retry
+		attempts := 1
+		proxyTimeout := timeout
+		if retry != nil {
+			if retry.Attempts > 1 && (req.Method == http.MethodGet || req.Method == http.MethodHead) {
+				attempts = retry.Attempts
+			}
+			if retry.Timeout > 0 {
+				proxyTimeout = retry.Timeout
+			}
+		}
+		var writer *streamWriter
+		var timedOut, completed bool
+		tried := []string{backend}
+		for i := 1; ; i++ {
+			writer = newStreamWriter(shouldStream)
+			timedOut, completed = doProxy(director, backend, targetURL, req, writer, proxyTimeout)
+			// 客户端已断开，不记录结果也不重试
+			if req.Context().Err() != nil {
+				break
+			}
+			reportBackend(director, backend, writer, timedOut, completed)
+			if i >= attempts || !shouldRetry(retry, writer, timedOut, completed) {
+				break
+			}
+			nextBackend := director.SelectNext(tried)
+			if len(nextBackend) == 0 {
+				break
+			}
+			nextURL, err := director.GetTargetURL(&nextBackend)
+			if err != nil {
+				break
+			}
+			// 丢弃本次的响应数据
+			writer.abort()
+			tried = append(tried, nextBackend)
+			backend = nextBackend
+			targetURL = nextURL
+			c.Retries++
 		}
+		c.ServerTiming.SetRetries(c.Retries)
+		c.Backend = backend
 
+		if validated != nil {
+			reqHeader.Del(pike.HeaderIfModifiedSince)
+			reqHeader.Del(pike.HeaderIfNoneMatch)
+		}
 		if len(ifModifiedSince) != 0 {
 			reqHeader.Set(pike.HeaderIfModifiedSince, ifModifiedSince)
 		}
 		if len(ifNoneMatch) != 0 {
 			reqHeader.Set(pike.HeaderIfNoneMatch, ifNoneMatch)
 		}
+		// 客户端已断开，不再处理响应数据
+		if req.Context().Err() != nil {
+			writer.abort()
+			done()
+			return ErrClientClosed
+		}
+		if timedOut {
+			done()
+			if useStaleIfError(c, client) {
+				return next()
+			}
+			return ErrGatewayTimeout
+		}
+		if !completed {
+			done()
+			if useStaleIfError(c, client) {
+				return next()
+			}
+			return ErrResponseAborted
+		}
 
 		headers := writer.Header()
 		if director.HeaderMap != nil {
@@ -244,9 +622,45 @@ func Proxy(config ProxyConfig) pike.Middleware {
 			}
 		}
 
+		// 直接转发的响应，数据由dispatcher读取后写至客户端
+		if writer.streaming {
+			cr := &cache.Response{
+				CreatedAt:  uint32(time.Now().Unix()),
+				StatusCode: uint16(writer.Status()),
+				Header:     headers,
+			}
+			if config.StreamCache && c.Status != cache.Pass {
+				cr.TTL = getCacheAge(headers)
+			}
+			if cr.TTL != 0 {
+				cr.StaleWhileRevalidate, cr.StaleIfError = getStaleAge(headers)
+			}
+			c.Resp = cr
+			c.Stream = writer.reader
+			done()
+			return next()
+		}
+
 		ttl := getCacheAge(headers)
 		body := writer.Bytes()
 		status := writer.Status()
+		// backend出错，如果有可用的过期缓存数据则使用
+		if status >= http.StatusInternalServerError && useStaleIfError(c, client) {
+			done()
+			return next()
+		}
+		// 缓存数据未修改，更新其有效期后直接使用，无需重新下载与压缩
+		if status == http.StatusNotModified && validated != nil {
+			refreshResponse(validated, headers)
+			c.Resp = validated
+			if validated.TTL != 0 {
+				client.SaveResponse(c.Identity, validated)
+				client.Cacheable(c.Identity, validated.TTL)
+				c.Status = cache.Cacheable
+			}
+			done()
+			return next()
+		}
 		// 如果是出错返回，则不需要生成ETag
 		// 因为出错的数据都不做缓存，提高性能
 		if config.ETag && (status >= http.StatusOK && status < http.StatusBadRequest) {
@@ -261,6 +675,9 @@ func Proxy(config ProxyConfig) pike.Middleware {
 			StatusCode: uint16(status),
 			Header:     headers,
 		}
+		if ttl != 0 {
+			cr.StaleWhileRevalidate, cr.StaleIfError = getStaleAge(headers)
+		}
 		contentEncoding := headers.Get(pike.HeaderContentEncoding)
 		if len(contentEncoding) == 0 {
 			cr.Body = body
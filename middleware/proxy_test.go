@@ -1,11 +1,16 @@
 package middleware
 
 import (
+	"context"
+	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/h2non/gock"
 	"github.com/vicanso/pike/cache"
@@ -77,6 +82,37 @@ func TestGetCacheAge(t *testing.T) {
 			t.Fatalf("response cache should get from max-age")
 		}
 	})
+
+	t.Run("max-age bigger than uint16", func(t *testing.T) {
+		header := make(http.Header)
+		header["Cache-Control"] = []string{
+			"max-age=86400",
+		}
+		if getCacheAge(header) != 86400 {
+			t.Fatalf("response cache of one day should not be truncated")
+		}
+		header["Cache-Control"] = []string{
+			"max-age=99999999999",
+		}
+		if getCacheAge(header) != math.MaxUint32 {
+			t.Fatalf("response cache bigger than uint32 should be max uint32")
+		}
+	})
+}
+
+func TestGetStaleAge(t *testing.T) {
+	header := make(http.Header)
+	header["Cache-Control"] = []string{
+		"max-age=60, stale-while-revalidate=30, stale-if-error=86400",
+	}
+	staleWhileRevalidate, staleIfError := getStaleAge(header)
+	if staleWhileRevalidate != 30 || staleIfError != 86400 {
+		t.Fatalf("get stale age fail")
+	}
+	staleWhileRevalidate, staleIfError = getStaleAge(make(http.Header))
+	if staleWhileRevalidate != 0 || staleIfError != 0 {
+		t.Fatalf("get stale age of no cache control should be 0")
+	}
 }
 
 func TestGenETag(t *testing.T) {
@@ -95,7 +131,7 @@ func TestProxy(t *testing.T) {
 	// 响应数据已从缓存中获取，next
 	t.Run("proxy with cache", func(t *testing.T) {
 		resp := &cache.Response{}
-		fn := Proxy(ProxyConfig{})
+		fn := Proxy(ProxyConfig{}, nil)
 		c := pike.NewContext(nil)
 		c.Resp = resp
 		err := fn(c, func() error {
@@ -112,7 +148,7 @@ func TestProxy(t *testing.T) {
 				"/api/*:/$1",
 			},
 			ETag: true,
-		})
+		}, nil)
 		req := httptest.NewRequest(http.MethodGet, "http://aslant.site/api/users/me", nil)
 		req.Header.Set(pike.HeaderIfModifiedSince, "Mon, 07 Nov 2016 07:51:11 GMT")
 		req.Header.Set(pike.HeaderIfNoneMatch, `"16e36-540b1498e39c0"`)
@@ -164,7 +200,7 @@ func TestProxy(t *testing.T) {
 	})
 
 	t.Run("director with rewrites", func(t *testing.T) {
-		fn := Proxy(ProxyConfig{})
+		fn := Proxy(ProxyConfig{}, nil)
 		req := httptest.NewRequest(http.MethodGet, "http://aslant.site/api/users/me", nil)
 		req.Header.Set(pike.HeaderIfModifiedSince, "Mon, 07 Nov 2016 07:51:11 GMT")
 		req.Header.Set(pike.HeaderIfNoneMatch, `"16e36-540b1498e39c0"`)
@@ -201,7 +237,7 @@ func TestProxy(t *testing.T) {
 	})
 
 	t.Run("proxy response gzip", func(t *testing.T) {
-		fn := Proxy(ProxyConfig{})
+		fn := Proxy(ProxyConfig{}, nil)
 		req := httptest.NewRequest(http.MethodGet, "http://aslant.site/api/users/me", nil)
 		c := pike.NewContext(req)
 		aslant := "aslant"
@@ -234,7 +270,7 @@ func TestProxy(t *testing.T) {
 	})
 
 	t.Run("proxy response br", func(t *testing.T) {
-		fn := Proxy(ProxyConfig{})
+		fn := Proxy(ProxyConfig{}, nil)
 		req := httptest.NewRequest(http.MethodGet, "http://aslant.site/api/users/me", nil)
 		c := pike.NewContext(req)
 		aslant := "aslant"
@@ -267,7 +303,7 @@ func TestProxy(t *testing.T) {
 	})
 
 	t.Run("proxy response unsupport encoding", func(t *testing.T) {
-		fn := Proxy(ProxyConfig{})
+		fn := Proxy(ProxyConfig{}, nil)
 		req := httptest.NewRequest(http.MethodGet, "http://aslant.site/api/users/me", nil)
 		c := pike.NewContext(req)
 		aslant := "aslant"
@@ -295,3 +331,490 @@ func TestProxy(t *testing.T) {
 		}
 	})
 }
+
+func TestProxyStaleIfError(t *testing.T) {
+	client := &cache.Client{
+		Driver: cache.MemoryStorage,
+	}
+	err := client.Init()
+	if err != nil {
+		t.Fatalf("cache init fail, %v", err)
+	}
+	defer client.Close()
+	defer gock.Off()
+	identity := []byte("GET aslant.site /stale")
+	client.GetRequestStatus(identity)
+	client.SaveResponse(identity, &cache.Response{
+		CreatedAt:    uint32(time.Now().Unix()) - 100,
+		StatusCode:   http.StatusOK,
+		TTL:          10,
+		StaleIfError: 3600,
+		Body:         []byte("stale"),
+	})
+	client.Cacheable(identity, 10)
+
+	backend := "http://127.0.0.1:5001"
+	gock.New(backend).
+		Get("/stale").
+		Reply(500)
+	fn := Proxy(ProxyConfig{}, client)
+	req := httptest.NewRequest(http.MethodGet, "http://aslant.site/stale", nil)
+	c := pike.NewContext(req)
+	d := &pike.Director{
+		Name:         "aslant",
+		TargetURLMap: make(map[string]*url.URL),
+	}
+	d.AddAvailableBackend(backend)
+	c.Director = d
+	c.Identity = identity
+	c.Status = cache.Fetching
+	err = fn(c, func() error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("proxy stale if error fail, %v", err)
+	}
+	if c.Status != cache.Stale || string(c.Resp.Body) != "stale" {
+		t.Fatalf("the stale response should be used when backend return 500")
+	}
+}
+
+func TestProxyConditionalRevalidate(t *testing.T) {
+	client := &cache.Client{
+		Driver: cache.MemoryStorage,
+	}
+	err := client.Init()
+	if err != nil {
+		t.Fatalf("cache init fail, %v", err)
+	}
+	defer client.Close()
+	defer gock.Off()
+	identity := []byte("GET aslant.site /revalidate")
+	client.GetRequestStatus(identity)
+	header := make(http.Header)
+	header.Set(pike.HeaderETag, `"abc"`)
+	header.Set(pike.HeaderCacheControl, "max-age=10")
+	client.SaveResponse(identity, &cache.Response{
+		CreatedAt:  uint32(time.Now().Unix()) - 100,
+		StatusCode: http.StatusOK,
+		TTL:        10,
+		Header:     header,
+		Body:       []byte("revalidate"),
+	})
+
+	backend := "http://127.0.0.1:5001"
+	gock.New(backend).
+		Get("/revalidate").
+		MatchHeader(pike.HeaderIfNoneMatch, `"abc"`).
+		Reply(304).
+		SetHeader(pike.HeaderCacheControl, "max-age=60")
+	fn := Proxy(ProxyConfig{}, client)
+	req := httptest.NewRequest(http.MethodGet, "http://aslant.site/revalidate", nil)
+	c := pike.NewContext(req)
+	d := &pike.Director{
+		Name:         "aslant",
+		TargetURLMap: make(map[string]*url.URL),
+	}
+	d.AddAvailableBackend(backend)
+	c.Director = d
+	c.Identity = identity
+	c.Status = cache.Fetching
+	err = fn(c, func() error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("proxy conditional revalidate fail, %v", err)
+	}
+	if c.Status != cache.Cacheable || c.Resp.TTL != 60 || string(c.Resp.Body) != "revalidate" {
+		t.Fatalf("the cache should be refreshed when backend return 304")
+	}
+	if req.Header.Get(pike.HeaderIfNoneMatch) != "" {
+		t.Fatalf("the conditional header should be removed after proxy")
+	}
+	status, _ := client.GetRequestStatus(identity)
+	if status != cache.Cacheable {
+		t.Fatalf("the refreshed cache should be cacheable")
+	}
+}
+
+func TestProxyStream(t *testing.T) {
+	defer gock.Off()
+	backend := "http://127.0.0.1:5001"
+	data := strings.Repeat("ABCD", 1024)
+	newContext := func(uri string, status int) *pike.Context {
+		req := httptest.NewRequest(http.MethodGet, "http://aslant.site"+uri, nil)
+		c := pike.NewContext(req)
+		d := &pike.Director{
+			Name:         "aslant",
+			TargetURLMap: make(map[string]*url.URL),
+		}
+		d.AddAvailableBackend(backend)
+		c.Director = d
+		c.Status = status
+		return c
+	}
+	readStream := func(c *pike.Context) string {
+		defer c.Stream.Close()
+		buf, _ := ioutil.ReadAll(c.Stream)
+		return string(buf)
+	}
+
+	t.Run("stream bigger than threshold", func(t *testing.T) {
+		gock.New(backend).
+			Get("/large").
+			Reply(200).
+			SetHeader(pike.HeaderContentLength, strconv.Itoa(len(data))).
+			SetHeader(pike.HeaderCacheControl, "max-age=60").
+			BodyString(data)
+		fn := Proxy(ProxyConfig{
+			StreamThreshold: 1024,
+			StreamCache:     true,
+		}, nil)
+		c := newContext("/large", cache.Fetching)
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("proxy stream fail, %v", err)
+		}
+		if c.Stream == nil || c.Resp.TTL != 60 || len(c.Resp.Body) != 0 {
+			t.Fatalf("the large response should be stream")
+		}
+		if readStream(c) != data {
+			t.Fatalf("the data of stream is wrong")
+		}
+	})
+
+	t.Run("stream pass", func(t *testing.T) {
+		gock.New(backend).
+			Get("/pass").
+			Reply(200).
+			SetHeader(pike.HeaderCacheControl, "max-age=60").
+			BodyString(data)
+		fn := Proxy(ProxyConfig{
+			StreamPass: true,
+		}, nil)
+		c := newContext("/pass", cache.Pass)
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("proxy stream pass fail, %v", err)
+		}
+		if c.Stream == nil || c.Resp.TTL != 0 {
+			t.Fatalf("the response of pass should be stream")
+		}
+		if readStream(c) != data {
+			t.Fatalf("the data of stream is wrong")
+		}
+	})
+
+	t.Run("not stream smaller than threshold", func(t *testing.T) {
+		gock.New(backend).
+			Get("/small").
+			Reply(200).
+			SetHeader(pike.HeaderContentLength, "4").
+			BodyString("ABCD")
+		fn := Proxy(ProxyConfig{
+			StreamThreshold: 1024,
+		}, nil)
+		c := newContext("/small", cache.Fetching)
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("proxy fail, %v", err)
+		}
+		if c.Stream != nil || string(c.Resp.Body) != "ABCD" {
+			t.Fatalf("the small response should not be stream")
+		}
+	})
+}
+
+func TestProxyStreamIdleTimeout(t *testing.T) {
+	// backend返回部分数据之后不再传输
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set(pike.HeaderContentLength, "4096")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ABCD"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer backend.Close()
+	req := httptest.NewRequest(http.MethodGet, "http://aslant.site/idle", nil)
+	c := pike.NewContext(req)
+	d := &pike.Director{
+		Name:         "aslant",
+		TargetURLMap: make(map[string]*url.URL),
+	}
+	d.AddAvailableBackend(backend.URL)
+	c.Director = d
+	c.Status = cache.Fetching
+	fn := Proxy(ProxyConfig{
+		Timeout:         50 * time.Millisecond,
+		StreamThreshold: 1024,
+	}, nil)
+	err := fn(c, func() error {
+		return nil
+	})
+	if err != nil || c.Stream == nil {
+		t.Fatalf("the response should be stream, %v", err)
+	}
+	defer c.Stream.Close()
+	startedAt := time.Now()
+	_, err = ioutil.ReadAll(c.Stream)
+	if err == nil {
+		t.Fatalf("the stream should be aborted when backend is idle")
+	}
+	if time.Since(startedAt) > 2*time.Second {
+		t.Fatalf("the stream should be aborted after the idle timeout")
+	}
+}
+
+func TestProxyPanic(t *testing.T) {
+	c := pike.NewContext(httptest.NewRequest(http.MethodGet, "http://aslant.site/panic", nil))
+	d := &pike.Director{
+		Name:         "aslant",
+		TargetURLMap: make(map[string]*url.URL),
+		// 获取proxy时panic（在proxy的goroutine中调用）
+		Transport: &http.Transport{
+			Proxy: func(*http.Request) (*url.URL, error) {
+				panic("proxy func panic")
+			},
+		},
+	}
+	d.AddAvailableBackend("http://127.0.0.1:5001")
+	c.Director = d
+	c.Status = cache.Pass
+	fn := Proxy(ProxyConfig{}, nil)
+	defer func() {
+		r := recover()
+		err, ok := r.(error)
+		if !ok || !strings.Contains(err.Error(), "proxy func panic") {
+			t.Fatalf("the panic of proxy should be raised in the caller goroutine, %v", r)
+		}
+	}()
+	fn(c, func() error {
+		return nil
+	})
+}
+
+func TestProxyCancel(t *testing.T) {
+	cancelled := make(chan bool, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case <-req.Context().Done():
+			cancelled <- true
+		case <-time.After(5 * time.Second):
+			cancelled <- false
+		}
+	}))
+	defer backend.Close()
+	newContext := func(req *http.Request) *pike.Context {
+		c := pike.NewContext(req)
+		d := &pike.Director{
+			Name:         "aslant",
+			TargetURLMap: make(map[string]*url.URL),
+		}
+		d.AddAvailableBackend(backend.URL)
+		c.Director = d
+		c.Status = cache.Fetching
+		return c
+	}
+
+	t.Run("cancel when timeout", func(t *testing.T) {
+		fn := Proxy(ProxyConfig{
+			Timeout: 50 * time.Millisecond,
+		}, nil)
+		req := httptest.NewRequest(http.MethodGet, "http://aslant.site/timeout", nil)
+		err := fn(newContext(req), func() error {
+			return nil
+		})
+		if err != ErrGatewayTimeout {
+			t.Fatalf("proxy should return timeout error")
+		}
+		if !<-cancelled {
+			t.Fatalf("the backend request should be cancelled after timeout")
+		}
+	})
+
+	t.Run("client closed", func(t *testing.T) {
+		fn := Proxy(ProxyConfig{}, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "http://aslant.site/closed", nil).WithContext(ctx)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cancel()
+		}()
+		err := fn(newContext(req), func() error {
+			return nil
+		})
+		if err != ErrClientClosed {
+			t.Fatalf("proxy should return client closed error")
+		}
+		if !<-cancelled {
+			t.Fatalf("the backend request should be cancelled after client closed")
+		}
+	})
+}
+
+func TestProxyRetry(t *testing.T) {
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer ok.Close()
+	// 已关闭的服务，连接失败
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	closed.Close()
+
+	newContext := func(req *http.Request, retry *pike.Retry, backends ...string) *pike.Context {
+		c := pike.NewContext(req)
+		d := &pike.Director{
+			Name:         "aslant",
+			Policy:       "first",
+			TargetURLMap: make(map[string]*url.URL),
+			Retry:        retry,
+		}
+		for _, backend := range backends {
+			d.AddAvailableBackend(backend)
+		}
+		c.Director = d
+		c.Status = cache.Pass
+		return c
+	}
+	fn := Proxy(ProxyConfig{}, nil)
+
+	t.Run("retry on status", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://aslant.site/", nil)
+		c := newContext(req, &pike.Retry{
+			Attempts: 2,
+		}, unavailable.URL, ok.URL)
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil || c.Resp.StatusCode != http.StatusOK || string(c.Resp.Body) != "ok" {
+			t.Fatalf("retry on 503 fail, %v", err)
+		}
+		if c.Retries != 1 || !strings.Contains(c.ServerTiming.String(), `retry;desc="1"`) {
+			t.Fatalf("the retries should be 1")
+		}
+	})
+
+	t.Run("retry on connection error", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodHead, "http://aslant.site/", nil)
+		c := newContext(req, &pike.Retry{
+			Attempts: 3,
+			Error:    true,
+		}, closed.URL, ok.URL)
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil || c.Resp.StatusCode != http.StatusOK || c.Retries != 1 {
+			t.Fatalf("retry on connection error fail, %v", err)
+		}
+
+		// 未配置连接出错重试
+		c = newContext(req, &pike.Retry{
+			Attempts: 3,
+		}, closed.URL, ok.URL)
+		fn(c, func() error {
+			return nil
+		})
+		if c.Resp.StatusCode != http.StatusBadGateway || c.Retries != 0 {
+			t.Fatalf("connection error should not retry")
+		}
+	})
+
+	t.Run("retry on timeout", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			time.Sleep(100 * time.Millisecond)
+			w.Write([]byte("slow"))
+		}))
+		defer slow.Close()
+		req := httptest.NewRequest(http.MethodGet, "http://aslant.site/", nil)
+		c := newContext(req, &pike.Retry{
+			Attempts: 2,
+			Error:    true,
+			Timeout:  20 * time.Millisecond,
+		}, slow.URL, ok.URL)
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil || string(c.Resp.Body) != "ok" || c.Retries != 1 {
+			t.Fatalf("retry on timeout fail, %v", err)
+		}
+	})
+
+	t.Run("no retry for post", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "http://aslant.site/", nil)
+		c := newContext(req, &pike.Retry{
+			Attempts: 2,
+		}, unavailable.URL, ok.URL)
+		fn(c, func() error {
+			return nil
+		})
+		if c.Resp.StatusCode != http.StatusServiceUnavailable || c.Retries != 0 {
+			t.Fatalf("post request should not retry")
+		}
+	})
+
+	t.Run("all backends tried", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://aslant.site/", nil)
+		c := newContext(req, &pike.Retry{
+			Attempts: 5,
+		}, unavailable.URL)
+		fn(c, func() error {
+			return nil
+		})
+		if c.Resp.StatusCode != http.StatusServiceUnavailable || c.Retries != 0 {
+			t.Fatalf("should not retry the same backend")
+		}
+	})
+}
+
+func TestProxyCircuitBreaker(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer backend.Close()
+	d := &pike.Director{
+		Name:         "aslant",
+		TargetURLMap: make(map[string]*url.URL),
+		CircuitBreaker: &pike.CircuitBreaker{
+			Failures: 2,
+		},
+	}
+	d.AddAvailableBackend(backend.URL)
+	fn := Proxy(ProxyConfig{}, nil)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://aslant.site/", nil)
+		c := pike.NewContext(req)
+		c.Director = d
+		c.Status = cache.Pass
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil || c.Resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("proxy fail, %v", err)
+		}
+	}
+	if !d.IsCircuitOpen(backend.URL) || len(d.GetAvailableBackends()) != 0 {
+		t.Fatalf("the backend should be ejected after continuous 500")
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://aslant.site/", nil)
+	c := pike.NewContext(req)
+	c.Director = d
+	err := fn(c, func() error {
+		return nil
+	})
+	if err != ErrNoBackendAvaliable {
+		t.Fatalf("no backend should be available")
+	}
+}
@@ -37,21 +37,22 @@ var (
 )
 
 // Recover 异常捕获，异常程序shutdown
+// 捕获的异常作为出错返回，由ErrorHandler处理（fetching中的请求需要设置状态）
 func Recover(config RecoverConfig) pike.Middleware {
-	return func(c *pike.Context, next pike.Next) error {
+	return func(c *pike.Context, next pike.Next) (err error) {
 		defer func() {
 			if r := recover(); r != nil {
 				performance.IncreaseRecoverCount()
-				err, ok := r.(error)
+				e, ok := r.(error)
 				if !ok {
-					err = fmt.Errorf("%v", r)
+					e = fmt.Errorf("%v", r)
 				}
 				stack := make([]byte, config.StackSize)
 				length := runtime.Stack(stack, !config.DisableStackAll)
 				if !config.DisablePrintStack {
-					log.Errorf("[PANIC RECOVER] %v %s\n", err, stack[:length])
+					log.Errorf("[PANIC RECOVER] %v %s\n", e, stack[:length])
 				}
-				c.Error(err)
+				err = e
 			}
 		}()
 		return next()
@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vicanso/pike/performance"
+	"github.com/vicanso/pike/pike"
+	"github.com/vicanso/pike/util"
+)
+
+const (
+	defaultUpgradeIdleTimeout = 60 * time.Second
+)
+
+type (
+	// UpgradeConfig upgrade请求（websocket等）的转发配置
+	UpgradeConfig struct {
+		// Rewrites url重写的配置（与proxy一致）
+		Rewrites []string
+		// Timeout 连接backend的超时，默认为10s
+		Timeout time.Duration
+		// IdleTimeout 连接无数据传输的超时，默认为60s
+		IdleTimeout time.Duration
+	}
+	// tunnel 客户端与backend的双向转发
+	tunnel struct {
+		idleTimeout time.Duration
+		// 最近一次传输数据的时间（纳秒）
+		activeAt int64
+	}
+)
+
+// isUpgradeRequest 判断是否upgrade的请求（Connection: Upgrade）
+func isUpgradeRequest(req *http.Request) bool {
+	if len(req.Header.Get("Upgrade")) == 0 {
+		return false
+	}
+	for _, value := range req.Header["Connection"] {
+		for _, v := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dialBackend 连接backend，https与wss使用tls
+func dialBackend(target *url.URL, timeout time.Duration) (net.Conn, error) {
+	host := target.Host
+	secure := target.Scheme == "https" || target.Scheme == "wss"
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		if secure {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+	dialer := &net.Dialer{
+		Timeout: timeout,
+	}
+	if secure {
+		return tls.DialWithDialer(dialer, "tcp", host, &tls.Config{
+			ServerName: target.Hostname(),
+		})
+	}
+	return dialer.Dial("tcp", host)
+}
+
+// getDialError 转换连接backend的出错，超时返回504，其它返回502（与proxy一致）
+func getDialError(err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return ErrGatewayTimeout
+	}
+	return ErrBadGateway
+}
+
+// copy 将src的数据转发至dst，无数据传输超时则结束
+func (t *tunnel) copy(dst, src net.Conn) {
+	buf := make([]byte, 32*1024)
+	for {
+		src.SetReadDeadline(time.Now().Add(t.idleTimeout))
+		n, err := src.Read(buf)
+		if n > 0 {
+			atomic.StoreInt64(&t.activeAt, time.Now().UnixNano())
+			dst.SetWriteDeadline(time.Now().Add(t.idleTimeout))
+			_, writeErr := dst.Write(buf[:n])
+			if writeErr != nil {
+				return
+			}
+		}
+		if err == nil {
+			continue
+		}
+		// 读取超时，但另一方向在超时时间内有数据传输，则继续等待
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			activeAt := atomic.LoadInt64(&t.activeAt)
+			if time.Since(time.Unix(0, activeAt)) < t.idleTimeout {
+				continue
+			}
+		}
+		return
+	}
+}
+
+// run 双向转发数据，任一方向结束则关闭连接
+func (t *tunnel) run(clientConn, backendConn net.Conn) {
+	atomic.StoreInt64(&t.activeAt, time.Now().UnixNano())
+	var once sync.Once
+	closeAll := func() {
+		clientConn.Close()
+		backendConn.Close()
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		t.copy(backendConn, clientConn)
+		once.Do(closeAll)
+	}()
+	go func() {
+		defer wg.Done()
+		t.copy(clientConn, backendConn)
+		once.Do(closeAll)
+	}()
+	wg.Wait()
+}
+
+// Upgrade 对upgrade的请求（websocket等）不经过缓存流程，直接与backend建立双向转发
+func Upgrade(config UpgradeConfig, directors pike.Directors) pike.Middleware {
+	rewriteRegexp := util.GetRewriteRegexp(config.Rewrites)
+	timeout := defaultTimeout
+	if config.Timeout > 0 {
+		timeout = config.Timeout
+	}
+	idleTimeout := defaultUpgradeIdleTimeout
+	if config.IdleTimeout > 0 {
+		idleTimeout = config.IdleTimeout
+	}
+	return func(c *pike.Context, next pike.Next) error {
+		req := c.Request
+		if !isUpgradeRequest(req) {
+			return next()
+		}
+		director := pickDirector(directors, req)
+		if director == nil {
+			return ErrDirectorNotFound
+		}
+		c.Director = director
+		backend := director.Select(c)
+		if len(backend) == 0 {
+			return ErrNoBackendAvaliable
+		}
+		c.Backend = backend
+		targetURL, err := director.GetTargetURL(&backend)
+		if err != nil {
+			return err
+		}
+		hj, ok := c.ResponseWriter.(http.Hijacker)
+		if !ok {
+			return ErrHijackNotSupport
+		}
+
+		rewrite(rewriteRegexp, req)
+		if director.RewriteRegexp != nil {
+			rewrite(director.RewriteRegexp, req)
+		}
+		outReq := new(http.Request)
+		*outReq = *req
+		u := *req.URL
+		u.Scheme = targetURL.Scheme
+		u.Host = targetURL.Host
+		u.Path = singleJoiningSlash(targetURL.Path, req.URL.Path)
+		outReq.URL = &u
+		outReq.Header = make(http.Header)
+		for k, v := range req.Header {
+			outReq.Header[k] = v
+		}
+		for k, v := range director.RequestHeaderMap {
+			outReq.Header.Add(k, v)
+		}
+		if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			if prior := outReq.Header.Get(pike.HeaderXForwardedFor); len(prior) != 0 {
+				ip = prior + ", " + ip
+			}
+			outReq.Header.Set(pike.HeaderXForwardedFor, ip)
+		}
+
+		backendConn, err := dialBackend(targetURL, timeout)
+		if err != nil {
+			return getDialError(err)
+		}
+		err = outReq.Write(backendConn)
+		if err != nil {
+			backendConn.Close()
+			return err
+		}
+		// 读取backend的响应头，backend拒绝upgrade（非101）则按普通请求返回其响应
+		backendConn.SetReadDeadline(time.Now().Add(timeout))
+		backendReader := bufio.NewReader(backendConn)
+		resp, err := http.ReadResponse(backendReader, outReq)
+		if err != nil {
+			backendConn.Close()
+			return getDialError(err)
+		}
+		header := c.Response.Header()
+		for k, values := range resp.Header {
+			for _, v := range values {
+				header.Add(k, v)
+			}
+		}
+		c.Response.WriteHeader(resp.StatusCode)
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			defer backendConn.Close()
+			_, err = io.Copy(c.Response, resp.Body)
+			return err
+		}
+		backendConn.SetReadDeadline(time.Time{})
+
+		clientConn, bufrw, err := hj.Hijack()
+		if err != nil {
+			backendConn.Close()
+			return err
+		}
+		c.Response.Committed = true
+		bufrw.WriteString("HTTP/1.1 " + resp.Status + "\r\n")
+		header.Write(bufrw)
+		bufrw.WriteString("\r\n")
+		// backend在响应头之后已发送的数据
+		if buffered := backendReader.Buffered(); buffered != 0 {
+			data, _ := backendReader.Peek(buffered)
+			bufrw.Write(data)
+		}
+		err = bufrw.Flush()
+		if err != nil {
+			clientConn.Close()
+			backendConn.Close()
+			return err
+		}
+		// 客户端已发送但未读取的数据
+		if buffered := bufrw.Reader.Buffered(); buffered != 0 {
+			data, _ := bufrw.Reader.Peek(buffered)
+			backendConn.Write(data)
+		}
+
+		performance.IncreaseUpgrading()
+		defer performance.DecreaseUpgrading()
+		// 长连接只记录正在处理的请求数（用于leastConn）
+		director.BeginRequest(backend)
+		defer director.EndRequest(backend, 0)
+		t := &tunnel{
+			idleTimeout: idleTimeout,
+		}
+		t.run(clientConn, backendConn)
+		return nil
+	}
+}
+
+// singleJoiningSlash 合并target与请求的path（与httputil.ReverseProxy一致）
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
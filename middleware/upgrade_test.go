@@ -0,0 +1,205 @@
+package middleware
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vicanso/pike/performance"
+	"github.com/vicanso/pike/pike"
+)
+
+func TestIsUpgradeRequest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	if isUpgradeRequest(req) {
+		t.Fatalf("normal request should not be upgrade")
+	}
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	if !isUpgradeRequest(req) {
+		t.Fatalf("the request should be upgrade")
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	// backend返回101之后，将收到的数据原样返回
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		conn, bufrw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufrw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		bufrw.Flush()
+		io.Copy(conn, bufrw)
+	}))
+	defer backend.Close()
+
+	d := &pike.Director{
+		Name:         "echo",
+		TargetURLMap: make(map[string]*url.URL),
+	}
+	d.AddAvailableBackend(backend.URL)
+	fn := Upgrade(UpgradeConfig{
+		IdleTimeout: time.Second,
+	}, pike.Directors{d})
+	status := make(chan int, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c := pike.NewContext(req)
+		c.ResponseWriter = w
+		err := fn(c, func() error {
+			w.WriteHeader(http.StatusNoContent)
+			return nil
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		status <- c.Response.Status()
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("connect to server fail, %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: aslant.site\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("upgrade request fail, %v", err)
+	}
+	if performance.GetUpgrading() != 1 {
+		t.Fatalf("the upgrading count should be 1")
+	}
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(reader, buf)
+	if err != nil || string(buf) != "ping" {
+		t.Fatalf("tunnel data fail, %v", err)
+	}
+
+	// 无数据传输超时后关闭连接
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, err = reader.ReadByte()
+	if err != io.EOF {
+		t.Fatalf("the tunnel should be closed after idle timeout, %v", err)
+	}
+	// 连接关闭与计数的更新并不同步，因此轮询等待
+	for i := 0; i < 100 && performance.GetUpgrading() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if performance.GetUpgrading() != 0 {
+		t.Fatalf("the upgrading count should be 0 after closed")
+	}
+	if v := <-status; v != http.StatusSwitchingProtocols {
+		t.Fatalf("the status of backend should be recorded, but %d", v)
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestUpgradeDialFail(t *testing.T) {
+	if getDialError(timeoutError{}) != ErrGatewayTimeout {
+		t.Fatalf("dial timeout should return gateway timeout")
+	}
+
+	// 获取一个未监听的端口，连接失败返回502
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen fail, %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	d := &pike.Director{
+		Name:         "echo",
+		TargetURLMap: make(map[string]*url.URL),
+	}
+	d.AddAvailableBackend("http://" + addr)
+	fn := Upgrade(UpgradeConfig{}, pike.Directors{d})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c := pike.NewContext(req)
+		c.ResponseWriter = w
+		err := fn(c, func() error {
+			return nil
+		})
+		if he, ok := err.(*pike.HTTPError); ok {
+			w.WriteHeader(he.Code)
+		}
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("connect to server fail, %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: aslant.site\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response fail, %v", err)
+	}
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("connect to backend fail should return 502, but %d", resp.StatusCode)
+	}
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	// backend不支持upgrade，返回普通的响应
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Reason", "not-support")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("forbidden"))
+	}))
+	defer backend.Close()
+
+	d := &pike.Director{
+		Name:         "echo",
+		TargetURLMap: make(map[string]*url.URL),
+	}
+	d.AddAvailableBackend(backend.URL)
+	fn := Upgrade(UpgradeConfig{}, pike.Directors{d})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c := pike.NewContext(req)
+		c.ResponseWriter = w
+		err := fn(c, func() error {
+			return nil
+		})
+		if err != nil || c.Response.Committed {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Reason", c.Response.Header().Get("X-Reason"))
+		w.WriteHeader(c.Response.Status())
+		w.Write(c.Response.Bytes())
+	}))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("connect to server fail, %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: aslant.site\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("read response fail, %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusForbidden ||
+		resp.Header.Get("X-Reason") != "not-support" ||
+		string(body) != "forbidden" {
+		t.Fatalf("the response of backend should be returned when upgrade is rejected, %d %s", resp.StatusCode, body)
+	}
+}
@@ -45,6 +45,10 @@ var (
 	spdy4Count uint64
 	// 出现recover的次数
 	recoverCount uint64
+	// 当前转发中的upgrade（websocket等）连接数
+	upgrading uint32
+	// 总的upgrade连接数
+	upgradeCount uint64
 )
 
 type (
@@ -92,6 +96,12 @@ type (
 		GoVersion string `json:"goVersion"`
 		// FileSize db数据文件的大小
 		FileSize int `json:"fileSize"`
+		// Evicted 因超出限制而被淘汰的缓存数量
+		Evicted uint64 `json:"evicted"`
+		// Upgrading 当前转发中的upgrade（websocket等）连接数
+		Upgrading uint32 `json:"upgrading"`
+		// UpgradeCount 总的upgrade连接数
+		UpgradeCount uint64 `json:"upgradeCount"`
 	}
 )
 
@@ -115,6 +125,22 @@ func IncreaseRecoverCount() uint64 {
 	return atomic.AddUint64(&recoverCount, 1)
 }
 
+// IncreaseUpgrading upgrade连接数加一
+func IncreaseUpgrading() uint32 {
+	atomic.AddUint64(&upgradeCount, 1)
+	return atomic.AddUint32(&upgrading, 1)
+}
+
+// DecreaseUpgrading upgrade连接数减一
+func DecreaseUpgrading() uint32 {
+	return atomic.AddUint32(&upgrading, ^uint32(0))
+}
+
+// GetUpgrading 获取当前的upgrade连接数
+func GetUpgrading() uint32 {
+	return atomic.LoadUint32(&upgrading)
+}
+
 // GetRequstCount 获取处理请求数
 func GetRequstCount() uint64 {
 	return requestCount
@@ -201,6 +227,9 @@ func GetStats(client *cache.Client) *Stats {
 		CommitID:     vars.CommitID,
 		GoVersion:    runtime.Version(),
 		FileSize:     result.FileSize,
+		Evicted:      result.Evicted,
+		Upgrading:    GetUpgrading(),
+		UpgradeCount: atomic.LoadUint64(&upgradeCount),
 	}
 	return stats
 }
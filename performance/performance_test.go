@@ -28,6 +28,15 @@ func TestPerformance(t *testing.T) {
 		}
 	})
 
+	t.Run("upgrading", func(t *testing.T) {
+		if IncreaseUpgrading() != 1 || GetUpgrading() != 1 {
+			t.Fatalf("inc upgrading fail")
+		}
+		if DecreaseUpgrading() != 0 || GetUpgrading() != 0 {
+			t.Fatalf("dec upgrading fail")
+		}
+	})
+
 	t.Run("get request count", func(t *testing.T) {
 		if IncreaseRequestCount() != 1 {
 			t.Fatalf("inc request count fail")
@@ -80,7 +89,7 @@ func TestPerformance(t *testing.T) {
 		c.Close()
 		stats := GetStats(c)
 		keys := funk.Keys(stats).([]string)
-		if len(keys) != 21 {
+		if len(keys) != 24 {
 			t.Fatalf("get stats fail")
 		}
 	})
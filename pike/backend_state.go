@@ -0,0 +1,112 @@
+package pike
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+)
+
+const (
+	// BackendEnabled backend正常使用（由health check决定是否可用）
+	BackendEnabled = "enabled"
+	// BackendDraining 不再分配新的请求（sticky的请求除外），正在处理的请求正常完成
+	BackendDraining = "draining"
+	// BackendDisabled 不再分配任何请求
+	BackendDisabled = "disabled"
+)
+
+var (
+	// ErrBackendStateInvalid backend的状态不合法
+	ErrBackendStateInvalid = errors.New("backend state should be enabled, draining or disabled")
+	// ErrBackendNotFound backend不存在
+	ErrBackendNotFound = errors.New("backend not found")
+)
+
+// GetBackendState 获取backend的状态（enabled draining disabled）
+func (d *Director) GetBackendState(backend string) string {
+	d.RLock()
+	defer d.RUnlock()
+	state := d.BackendStates[backend]
+	if len(state) == 0 {
+		return BackendEnabled
+	}
+	return state
+}
+
+// isBackendEnabled 判断backend是否未被设置为draining或disabled
+func (d *Director) isBackendEnabled(backend string) bool {
+	return d.GetBackendState(backend) == BackendEnabled
+}
+
+// SetBackendState 设置backend的状态，draining与disabled会覆盖health check的结果，
+// 设置为enabled之后如果health check正常则重新可用
+func (d *Director) SetBackendState(backend, state string) error {
+	if state != BackendEnabled && state != BackendDraining && state != BackendDisabled {
+		return ErrBackendStateInvalid
+	}
+	if !d.hasBackend(backend) {
+		return ErrBackendNotFound
+	}
+	d.Lock()
+	// 重新生成map，避免输出时并发读写
+	m := make(map[string]string)
+	for k, v := range d.BackendStates {
+		m[k] = v
+	}
+	if state == BackendEnabled {
+		delete(m, backend)
+	} else {
+		m[backend] = state
+	}
+	if len(m) == 0 {
+		m = nil
+	}
+	d.BackendStates = m
+	h, checked := d.HealthStatus[backend]
+	d.Unlock()
+
+	if state != BackendEnabled {
+		d.RemoveAvailableBackend(backend)
+		return nil
+	}
+	if checked && h.Healthy && !d.IsCircuitOpen(backend) {
+		d.AddAvailableBackend(backend)
+	}
+	return nil
+}
+
+// hasBackend 判断是否为director的backend
+func (d *Director) hasBackend(backend string) bool {
+	for _, item := range d.GetBackends() {
+		if item == backend {
+			return true
+		}
+	}
+	return false
+}
+
+// getInflights 获取各backend正在处理的请求数（用于判断draining是否完成）
+func (d *Director) getInflights() map[string]int64 {
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	if len(d.stats) == 0 {
+		return nil
+	}
+	m := make(map[string]int64)
+	for backend, stats := range d.stats {
+		m[backend] = atomic.LoadInt64(&stats.inflight)
+	}
+	return m
+}
+
+// MarshalJSON 输出director的配置与状态（包括各backend正在处理的请求数）
+func (d *Director) MarshalJSON() ([]byte, error) {
+	type director Director
+	return json.Marshal(&struct {
+		*director
+		Inflights map[string]int64 `json:"inflights,omitempty"`
+	}{
+		director:  (*director)(d),
+		Inflights: d.getInflights(),
+	})
+}
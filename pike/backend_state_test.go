@@ -0,0 +1,155 @@
+package pike
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackendState(t *testing.T) {
+	backends := []string{
+		"http://127.0.0.1:5001",
+		"http://127.0.0.1:5002",
+	}
+	newDirector := func() *Director {
+		d := &Director{
+			Backends: backends,
+		}
+		for _, backend := range backends {
+			d.updateHealth(backend, nil)
+			d.AddAvailableBackend(backend)
+		}
+		return d
+	}
+
+	t.Run("invalid state", func(t *testing.T) {
+		d := newDirector()
+		if d.SetBackendState(backends[0], "stop") != ErrBackendStateInvalid {
+			t.Fatalf("invalid state should return error")
+		}
+		if d.SetBackendState("http://127.0.0.1:5003", BackendDraining) != ErrBackendNotFound {
+			t.Fatalf("not exists backend should return error")
+		}
+	})
+
+	t.Run("drain and enable", func(t *testing.T) {
+		d := newDirector()
+		err := d.SetBackendState(backends[0], BackendDraining)
+		if err != nil {
+			t.Fatalf("set backend state fail, %v", err)
+		}
+		if d.GetBackendState(backends[0]) != BackendDraining || d.GetBackendState(backends[1]) != BackendEnabled {
+			t.Fatalf("get backend state fail")
+		}
+		for i := 0; i < 5; i++ {
+			if d.Select(NewContext(nil)) != backends[1] {
+				t.Fatalf("the draining backend should not be selected")
+			}
+		}
+		err = d.SetBackendState(backends[0], BackendEnabled)
+		if err != nil {
+			t.Fatalf("set backend state fail, %v", err)
+		}
+		if len(d.BackendStates) != 0 || len(d.GetAvailableBackends()) != 2 {
+			t.Fatalf("the enabled backend should be available")
+		}
+	})
+
+	t.Run("health check", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen fail, %v", err)
+		}
+		defer ln.Close()
+		backend := "http://" + ln.Addr().String()
+		d := &Director{
+			Backends: []string{
+				backend,
+			},
+			HealthCheckConfig: &HealthCheckConfig{
+				TCP: true,
+			},
+		}
+		d.SetBackendState(backend, BackendDisabled)
+		// health check的结果不会使backend重新可用
+		d.checkBackend(backend)
+		if !d.HealthStatus[backend].Healthy || len(d.GetAvailableBackends()) != 0 {
+			t.Fatalf("the disabled backend should not be available")
+		}
+		d.SetBackendState(backend, BackendEnabled)
+		if len(d.GetAvailableBackends()) != 1 {
+			t.Fatalf("the enabled backend should be available")
+		}
+	})
+
+	t.Run("enable sick backend", func(t *testing.T) {
+		d := newDirector()
+		d.SetBackendState(backends[0], BackendDisabled)
+		d.updateHealth(backends[0], errHealthCheckBodyNotMatch)
+		d.SetBackendState(backends[0], BackendEnabled)
+		if len(d.GetAvailableBackends()) != 1 {
+			t.Fatalf("the sick backend should not be available after enabled")
+		}
+	})
+
+	t.Run("sticky", func(t *testing.T) {
+		d := newDirector()
+		d.Policy = sticky
+		d.StickyConfig = &StickyConfig{
+			Secret: "secret",
+		}
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.AddCookie(d.GetStickyCookie(NewContext(req), backends[0]))
+		c := NewContext(req)
+
+		d.SetBackendState(backends[0], BackendDraining)
+		if d.Select(c) != backends[0] {
+			t.Fatalf("the sticky request should use the draining backend")
+		}
+		if d.Select(NewContext(nil)) != backends[1] {
+			t.Fatalf("the new request should not use the draining backend")
+		}
+		d.SetBackendState(backends[0], BackendDisabled)
+		if d.Select(c) != backends[1] {
+			t.Fatalf("the sticky request should not use the disabled backend")
+		}
+	})
+
+	t.Run("inherit", func(t *testing.T) {
+		old := newDirector()
+		old.SetBackendState(backends[0], BackendDisabled)
+		d := &Director{
+			Backends: backends,
+		}
+		d.Inherit(old)
+		if d.GetBackendState(backends[0]) != BackendDisabled {
+			t.Fatalf("should inherit the backend state")
+		}
+		available := d.GetAvailableBackends()
+		if len(available) != 1 || available[0] != backends[1] {
+			t.Fatalf("the disabled backend should not be available")
+		}
+	})
+
+	t.Run("marshal json", func(t *testing.T) {
+		d := newDirector()
+		d.SetBackendState(backends[0], BackendDraining)
+		d.BeginRequest(backends[0])
+		defer d.EndRequest(backends[0], time.Millisecond)
+		buf, err := json.Marshal(d)
+		if err != nil {
+			t.Fatalf("marshal director fail, %v", err)
+		}
+		data := struct {
+			BackendStates map[string]string `json:"backendStates"`
+			Inflights     map[string]int64  `json:"inflights"`
+		}{}
+		json.Unmarshal(buf, &data)
+		if data.BackendStates[backends[0]] != BackendDraining || data.Inflights[backends[0]] != 1 {
+			t.Fatalf("should output the backend state and inflight, %s", string(buf))
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package pike
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// leastConn 选择正在处理请求数最少的backend（按权重）
+	leastConn = "leastConn"
+	// ewma 选择响应时间（peak ewma）与正在处理请求数综合最优的backend
+	ewma = "ewma"
+	// ewma的衰减时间常数
+	ewmaDecay = 10 * time.Second
+	// 未有响应时间的backend使用的默认响应时间
+	ewmaPenalty = float64(time.Second)
+)
+
+type (
+	// backendStats backend正在处理的请求数与响应时间，
+	// 重新加载配置时由新的director继承（原director正在处理的请求完成时也会更新）
+	backendStats struct {
+		inflight int64
+		sync.Mutex
+		// 响应时间的ewma（纳秒）
+		latency   float64
+		updatedAt time.Time
+	}
+)
+
+// getStats 获取backend的统计，如果不存在则创建
+func (d *Director) getStats(backend string) *backendStats {
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	if d.stats == nil {
+		d.stats = make(map[string]*backendStats)
+	}
+	stats := d.stats[backend]
+	if stats == nil {
+		stats = &backendStats{}
+		d.stats[backend] = stats
+	}
+	return stats
+}
+
+// inheritStats 继承原有director中backend的统计（仍存在的backend）
+func (d *Director) inheritStats(old *Director, backends []string) {
+	old.statsLock.Lock()
+	m := make(map[string]*backendStats)
+	for _, backend := range backends {
+		if stats := old.stats[backend]; stats != nil {
+			m[backend] = stats
+		}
+	}
+	old.statsLock.Unlock()
+	if len(m) == 0 {
+		return
+	}
+	d.statsLock.Lock()
+	defer d.statsLock.Unlock()
+	d.stats = m
+}
+
+// BeginRequest 记录backend开始处理请求
+func (d *Director) BeginRequest(backend string) {
+	atomic.AddInt64(&d.getStats(backend).inflight, 1)
+}
+
+// EndRequest 记录backend完成请求，latency为0则不记录响应时间（如websocket）
+func (d *Director) EndRequest(backend string, latency time.Duration) {
+	stats := d.getStats(backend)
+	atomic.AddInt64(&stats.inflight, -1)
+	if latency <= 0 {
+		return
+	}
+	stats.Lock()
+	defer stats.Unlock()
+	now := time.Now()
+	value := float64(latency)
+	// peak ewma：响应时间变长则直接使用，变短则按时间衰减
+	if value > stats.latency {
+		stats.latency = value
+	} else {
+		w := math.Exp(-float64(now.Sub(stats.updatedAt)) / float64(ewmaDecay))
+		stats.latency = stats.latency*w + value*(1-w)
+	}
+	stats.updatedAt = now
+}
+
+// GetInflight 获取backend正在处理的请求数
+func (d *Director) GetInflight(backend string) int64 {
+	return atomic.LoadInt64(&d.getStats(backend).inflight)
+}
+
+// getLatency 获取backend响应时间的ewma
+func (d *Director) getLatency(backend string) float64 {
+	stats := d.getStats(backend)
+	stats.Lock()
+	defer stats.Unlock()
+	return stats.latency
+}
+
+// selectLeast 选择cost最低的backend，相同的则轮流选择
+func selectLeast(d *Director, backends []string, cost func(i int, backend string) float64) uint32 {
+	count := len(backends)
+	if count == 0 {
+		return 0
+	}
+	start := int(atomic.AddUint32(&d.roubin, 1) % uint32(count))
+	index := start
+	min := math.Inf(1)
+	for i := 0; i < count; i++ {
+		j := (start + i) % count
+		v := cost(j, backends[j])
+		if v < min {
+			min = v
+			index = j
+		}
+	}
+	return uint32(index)
+}
+
+// selectLeastConn 选择正在处理请求数（除以权重）最少的backend
+func selectLeastConn(c *Context, d *Director, backends []string) uint32 {
+	weights, _ := d.getEffectiveWeights(backends)
+	return selectLeast(d, backends, func(i int, backend string) float64 {
+		return float64(d.GetInflight(backend)) / weights[i]
+	})
+}
+
+// selectEWMA 选择响应时间ewma乘以(正在处理请求数+1)最小的backend，
+// 未有响应时间的backend优先选择（正在处理请求时使用默认的响应时间）
+func selectEWMA(c *Context, d *Director, backends []string) uint32 {
+	return selectLeast(d, backends, func(i int, backend string) float64 {
+		latency := d.getLatency(backend)
+		inflight := d.GetInflight(backend)
+		if latency == 0 && inflight != 0 {
+			latency = ewmaPenalty
+		}
+		return latency * float64(inflight+1)
+	})
+}
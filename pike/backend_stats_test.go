@@ -0,0 +1,96 @@
+package pike
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackendStats(t *testing.T) {
+	d := &Director{}
+	d.BeginRequest("a")
+	d.BeginRequest("a")
+	if d.GetInflight("a") != 2 {
+		t.Fatalf("the inflight should be 2")
+	}
+	d.EndRequest("a", 100*time.Millisecond)
+	if d.GetInflight("a") != 1 || d.getLatency("a") != float64(100*time.Millisecond) {
+		t.Fatalf("end request should update inflight and latency")
+	}
+	// 响应时间变长则直接使用
+	d.EndRequest("a", 200*time.Millisecond)
+	if d.GetInflight("a") != 0 || d.getLatency("a") != float64(200*time.Millisecond) {
+		t.Fatalf("the peak latency should be used")
+	}
+	// 响应时间变短则衰减
+	d.EndRequest("a", 0)
+	d.EndRequest("a", 10*time.Millisecond)
+	latency := d.getLatency("a")
+	if latency >= float64(200*time.Millisecond) || latency <= float64(10*time.Millisecond) {
+		t.Fatalf("the latency should decay, %v", latency)
+	}
+}
+
+func TestLeastSelect(t *testing.T) {
+	c := NewContext(httptest.NewRequest(http.MethodGet, "/", nil))
+	newDirector := func(policy string) *Director {
+		d := &Director{
+			Policy: policy,
+		}
+		d.AddAvailableBackend("a")
+		d.AddAvailableBackend("b")
+		d.AddAvailableBackend("c")
+		return d
+	}
+
+	t.Run("leastConn", func(t *testing.T) {
+		d := newDirector(leastConn)
+		d.BeginRequest("a")
+		d.BeginRequest("c")
+		for i := 0; i < 3; i++ {
+			if d.Select(c) != "b" {
+				t.Fatalf("should select the backend with least connections")
+			}
+		}
+		d.BeginRequest("b")
+		d.BeginRequest("b")
+		d.EndRequest("a", time.Millisecond)
+		if d.Select(c) != "a" {
+			t.Fatalf("should select the backend with least connections")
+		}
+	})
+
+	t.Run("leastConn with weight", func(t *testing.T) {
+		d := newDirector(leastConn)
+		d.Weights = map[string]int{
+			"a": 4,
+		}
+		for i := 0; i < 3; i++ {
+			d.BeginRequest("a")
+		}
+		d.BeginRequest("b")
+		d.BeginRequest("c")
+		if d.Select(c) != "a" {
+			t.Fatalf("should select the backend with least connections per weight")
+		}
+	})
+
+	t.Run("ewma", func(t *testing.T) {
+		d := newDirector(ewma)
+		for _, backend := range []string{"a", "b", "c"} {
+			d.BeginRequest(backend)
+		}
+		d.EndRequest("a", 30*time.Millisecond)
+		d.EndRequest("b", 10*time.Millisecond)
+		d.EndRequest("c", 20*time.Millisecond)
+		if d.Select(c) != "b" {
+			t.Fatalf("should select the backend with least latency")
+		}
+		d.BeginRequest("b")
+		d.BeginRequest("b")
+		if d.Select(c) != "c" {
+			t.Fatalf("should select the backend with least latency * (inflight + 1)")
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package pike
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// circuitClosed 正常状态
+	circuitClosed = iota
+	// circuitOpen 熔断状态，backend从可用列表中剔除
+	circuitOpen
+	// circuitHalfOpen 冷却时间已过，等待试探请求的结果
+	circuitHalfOpen
+)
+
+const (
+	defaultCircuitFailures = 5
+	defaultCircuitCoolDown = 10 * time.Second
+)
+
+var (
+	// 默认判断为失败的响应状态码
+	defaultCircuitStatus = []int{
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
+)
+
+type (
+	// CircuitBreaker 根据实际请求的结果（被动健康检测）对backend熔断的配置
+	CircuitBreaker struct {
+		// Failures 连续失败多少次则熔断，默认为5
+		Failures int `json:"failures"`
+		// CoolDown 熔断后的冷却时间，之后使用一个请求试探，默认为10s
+		CoolDown time.Duration `json:"coolDown"`
+		// Status 判断为失败的响应状态码，默认为500 502 503 504
+		Status []int `json:"status"`
+	}
+	// circuit backend的熔断状态
+	circuit struct {
+		status   int
+		failures int
+		// 熔断（或开始试探）的时间
+		changedAt time.Time
+	}
+	// circuits director下所有backend的熔断状态
+	circuits struct {
+		sync.Mutex
+		m map[string]*circuit
+	}
+)
+
+func (cb *CircuitBreaker) getFailures() int {
+	if cb.Failures <= 0 {
+		return defaultCircuitFailures
+	}
+	return cb.Failures
+}
+
+func (cb *CircuitBreaker) getCoolDown() time.Duration {
+	if cb.CoolDown <= 0 {
+		return defaultCircuitCoolDown
+	}
+	return cb.CoolDown
+}
+
+// IsFailure 判断响应状态码是否为失败
+func (cb *CircuitBreaker) IsFailure(status int) bool {
+	statusList := cb.Status
+	if len(statusList) == 0 {
+		statusList = defaultCircuitStatus
+	}
+	for _, v := range statusList {
+		if v == status {
+			return true
+		}
+	}
+	return false
+}
+
+// get 获取backend的熔断状态，如果不存在则创建
+func (cs *circuits) get(backend string) *circuit {
+	if cs.m == nil {
+		cs.m = make(map[string]*circuit)
+	}
+	item := cs.m[backend]
+	if item == nil {
+		item = &circuit{}
+		cs.m[backend] = item
+	}
+	return item
+}
+
+// inheritCircuits 继承原有director中backend的熔断状态（仍存在的backend）
+func (d *Director) inheritCircuits(old *Director, backends []string) {
+	if d.CircuitBreaker == nil {
+		return
+	}
+	ocs := &old.circuits
+	ocs.Lock()
+	m := make(map[string]*circuit)
+	for _, backend := range backends {
+		if item := ocs.m[backend]; item != nil {
+			c := *item
+			m[backend] = &c
+		}
+	}
+	ocs.Unlock()
+	if len(m) == 0 {
+		return
+	}
+	cs := &d.circuits
+	cs.Lock()
+	defer cs.Unlock()
+	cs.m = m
+}
+
+// IsCircuitOpen 判断backend是否已熔断（包括等待试探结果）
+func (d *Director) IsCircuitOpen(backend string) bool {
+	cs := &d.circuits
+	cs.Lock()
+	defer cs.Unlock()
+	item := cs.m[backend]
+	return item != nil && item.status != circuitClosed
+}
+
+// selectTrial 选择冷却时间已过的熔断backend做试探请求，每次只允许一个试探请求，
+// health check检测为不可用的backend不做试探
+func (d *Director) selectTrial() string {
+	cb := d.CircuitBreaker
+	if cb == nil {
+		return ""
+	}
+	coolDown := cb.getCoolDown()
+	cs := &d.circuits
+	cs.Lock()
+	defer cs.Unlock()
+	now := time.Now()
+	for backend, item := range cs.m {
+		if item.status == circuitClosed || now.Sub(item.changedAt) < coolDown ||
+			!d.isBackendEnabled(backend) || !d.isBackendHealthy(backend) {
+			continue
+		}
+		// 试探请求一直未有结果（如客户端中断），超过冷却时间则重新试探
+		item.status = circuitHalfOpen
+		item.changedAt = now
+		return backend
+	}
+	return ""
+}
+
+// Report 记录backend请求的结果，连续失败则熔断，试探成功则恢复
+func (d *Director) Report(backend string, success bool) {
+	cb := d.CircuitBreaker
+	if cb == nil {
+		return
+	}
+	cs := &d.circuits
+	cs.Lock()
+	item := cs.get(backend)
+	status := item.status
+	// 熔断期间的结果来自熔断前已发出的请求，忽略（等待冷却后的试探请求）
+	if status == circuitOpen {
+		cs.Unlock()
+		return
+	}
+	if success {
+		item.status = circuitClosed
+		item.failures = 0
+	} else {
+		item.failures++
+		if status == circuitHalfOpen || item.failures >= cb.getFailures() {
+			item.status = circuitOpen
+			item.changedAt = time.Now()
+		}
+	}
+	current := item.status
+	cs.Unlock()
+
+	if status != circuitClosed && current == circuitClosed {
+		// 试探成功，重新加入可用列表（draining与disabled、health check不可用的除外）
+		if d.isBackendEnabled(backend) && d.isBackendHealthy(backend) {
+			d.AddAvailableBackend(backend)
+		}
+	} else if status == circuitClosed && current == circuitOpen {
+		d.RemoveAvailableBackend(backend)
+	}
+}
@@ -0,0 +1,136 @@
+package pike
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	backend := "http://127.0.0.1:5001"
+	d := &Director{
+		Name: "tiny",
+		CircuitBreaker: &CircuitBreaker{
+			Failures: 2,
+			CoolDown: 50 * time.Millisecond,
+		},
+	}
+	d.AddAvailableBackend(backend)
+	d.AddAvailableBackend("http://127.0.0.1:5002")
+	c := NewContext(httptest.NewRequest(http.MethodGet, "/", nil))
+
+	t.Run("is failure", func(t *testing.T) {
+		cb := &CircuitBreaker{}
+		if !cb.IsFailure(http.StatusBadGateway) || cb.IsFailure(http.StatusNotFound) {
+			t.Fatalf("check failure status by default fail")
+		}
+		cb.Status = []int{http.StatusNotFound}
+		if cb.IsFailure(http.StatusBadGateway) || !cb.IsFailure(http.StatusNotFound) {
+			t.Fatalf("check failure status by config fail")
+		}
+	})
+
+	t.Run("open circuit", func(t *testing.T) {
+		d.Report(backend, false)
+		d.Report(backend, true)
+		d.Report(backend, false)
+		if d.IsCircuitOpen(backend) {
+			t.Fatalf("success response should reset the failures")
+		}
+		d.Report(backend, false)
+		if !d.IsCircuitOpen(backend) || len(d.GetAvailableBackends()) != 1 {
+			t.Fatalf("the backend should be ejected after continuous failures")
+		}
+		if d.Select(c) == backend {
+			t.Fatalf("the ejected backend should not be selected")
+		}
+	})
+
+	t.Run("success while open", func(t *testing.T) {
+		// 熔断前已发出的请求成功，不应跳过冷却时间
+		d.Report(backend, true)
+		if !d.IsCircuitOpen(backend) || len(d.GetAvailableBackends()) != 1 {
+			t.Fatalf("the success while circuit open should be ignored")
+		}
+	})
+
+	t.Run("half open", func(t *testing.T) {
+		time.Sleep(60 * time.Millisecond)
+		if d.Select(c) != backend {
+			t.Fatalf("the backend should be selected for trial after cool down")
+		}
+		if d.Select(c) == backend {
+			t.Fatalf("only one trial request is allowed")
+		}
+		// 试探失败，重新熔断
+		d.Report(backend, false)
+		if !d.IsCircuitOpen(backend) || d.Select(c) == backend {
+			t.Fatalf("the backend should be ejected after trial fail")
+		}
+
+		time.Sleep(60 * time.Millisecond)
+		if d.Select(c) != backend {
+			t.Fatalf("the backend should be selected for trial after cool down")
+		}
+		d.Report(backend, true)
+		if d.IsCircuitOpen(backend) || len(d.GetAvailableBackends()) != 2 {
+			t.Fatalf("the backend should be available after trial success")
+		}
+	})
+
+	t.Run("unhealthy", func(t *testing.T) {
+		d.Report(backend, false)
+		d.Report(backend, false)
+		if !d.IsCircuitOpen(backend) {
+			t.Fatalf("the backend should be ejected after continuous failures")
+		}
+		// health check检测为不可用，冷却时间过后也不做试探
+		d.updateHealth(backend, errors.New("connection refused"))
+		time.Sleep(60 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			if d.Select(c) == backend {
+				t.Fatalf("the unhealthy backend should not be selected for trial")
+			}
+		}
+		d.updateHealth(backend, nil)
+		if d.Select(c) != backend {
+			t.Fatalf("the backend should be selected for trial after healthy")
+		}
+	})
+}
+
+func TestReportConcurrency(t *testing.T) {
+	backends := []string{
+		"http://127.0.0.1:5001",
+		"http://127.0.0.1:5002",
+		"http://127.0.0.1:5003",
+	}
+	d := &Director{
+		Name: "tiny",
+		CircuitBreaker: &CircuitBreaker{
+			Failures: 1,
+			CoolDown: time.Millisecond,
+		},
+	}
+	for _, backend := range backends {
+		d.AddAvailableBackend(backend)
+	}
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < 1000; i++ {
+			backend := backends[i%len(backends)]
+			d.Report(backend, false)
+			d.Report(backend, true)
+			d.AddAvailableBackend(backend)
+		}
+		done <- true
+	}()
+	// 选择backend时与熔断修改可用列表并发，使用-race检测
+	for i := 0; i < 1000; i++ {
+		d.Select(NewContext(nil))
+		d.SelectNext([]string{backends[0]})
+	}
+	<-done
+}
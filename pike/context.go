@@ -2,6 +2,7 @@ package pike
 
 import (
 	"encoding/json"
+	"io"
 	"net"
 	"net/http"
 	"strconv"
@@ -20,14 +21,22 @@ type (
 		ServerTiming   *ServerTiming
 		// Status 该请求的状态 fetching pass等
 		Status int
-		// Identity 该请求的标记
+		// Identity 该请求的标记（如果响应有Vary，则为包含Vary字段值的标记）
 		Identity []byte
+		// BaseIdentity 该请求未区分Vary的标记
+		BaseIdentity []byte
 		// Director 该请求对应的director
 		Director *Director
+		// Backend 该请求proxy时使用的backend
+		Backend string
 		// Resp 该请求的响应数据
 		Resp *cache.Response
+		// Stream 直接转发的响应数据（不可缓存或数据较大时使用，Resp中无数据）
+		Stream io.ReadCloser
 		// Fresh 是否fresh
 		Fresh bool
+		// Retries proxy时重试的次数
+		Retries int
 		// CreatedAt 创建时间
 		CreatedAt time.Time
 	}
@@ -55,9 +64,13 @@ func NewContext(req *http.Request) (c *Context) {
 func (c *Context) Reset() {
 	c.Status = 0
 	c.Identity = nil
+	c.BaseIdentity = nil
 	c.Director = nil
+	c.Backend = ""
 	c.Resp = nil
+	c.Stream = nil
 	c.Fresh = false
+	c.Retries = 0
 	c.CreatedAt = time.Now()
 }
 
@@ -3,16 +3,13 @@ package pike
 import (
 	"errors"
 	"hash/fnv"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
 	"sync"
-	"sync/atomic"
 	"time"
 
-	log "github.com/sirupsen/logrus"
 	funk "github.com/thoas/go-funk"
 	"github.com/vicanso/pike/util"
 )
@@ -30,8 +27,10 @@ type (
 		Backends []string `json:"backends"`
 		// 可用的backend列表（通过ping检测）
 		AvailableBackends []string `json:"availableBackends"`
-		// host列表
+		// host列表（正则，修改后需要调用GenHostRegexp，或使用AddHost与RemoveHost）
 		Hosts []string `json:"hosts"`
+		// hostRegexps host列表对应的正则
+		hostRegexps []*regexp.Regexp
 		// url前缀
 		Prefixs []string `json:"prefixs"`
 		// Rewrites 需要重写的url配置
@@ -56,24 +55,78 @@ type (
 		Transport *http.Transport `json:"-"`
 		// TargetURLMap 每个backend对应的URL对象
 		TargetURLMap map[string]*url.URL `json:"-"`
+		// Retry 请求失败时的重试配置（只对GET HEAD请求重试）
+		Retry *Retry `json:"retry,omitempty"`
+		// CircuitBreaker 根据实际请求结果熔断backend的配置
+		CircuitBreaker *CircuitBreaker `json:"circuitBreaker,omitempty"`
+		// circuits 各backend的熔断状态
+		circuits circuits
+		// HealthCheckConfig 主动健康检测的配置
+		HealthCheckConfig *HealthCheckConfig `json:"healthCheck,omitempty"`
+		// HealthStatus 各backend最近一次健康检测的结果
+		HealthStatus map[string]BackendHealth `json:"healthStatus,omitempty"`
+		// BackendStates 通过admin设置为draining或disabled的backend（覆盖health check的结果）
+		BackendStates map[string]string `json:"backendStates,omitempty"`
+		// Weights backend的权重（未配置的为1）
+		Weights map[string]int `json:"weights,omitempty"`
+		// SlowStart backend恢复可用后权重逐步增加的时长
+		SlowStart time.Duration `json:"slowStart,omitempty"`
+		// availableAt backend加入可用列表的时间
+		availableAt map[string]time.Time
+		// 平滑加权轮询各backend的当前权重
+		wrrLock        sync.Mutex
+		currentWeights map[string]float64
+		// 各backend正在处理的请求数与响应时间
+		statsLock sync.Mutex
+		stats     map[string]*backendStats
+		// ring 可用backend的一致性hash环（可用列表变化时重置）
+		ring *hashRing
+		// StickyConfig sticky策略的cookie配置
+		StickyConfig *StickyConfig `json:"sticky,omitempty"`
+		stickyOnce   sync.Once
+		stickyConf   *StickyConfig
+		// Discovery backend自动发现的配置
+		Discovery *DiscoveryConfig `json:"discovery,omitempty"`
+		// discovered 通过discovery增加的backend
+		discovered map[string]bool
+		// 停止health check与discovery
+		healthCheckStop    chan struct{}
+		healthCheckStopped bool
+		// 是否已启用health check
+		healthChecking int32
+	}
+	// Retry 请求失败时重试的配置
+	Retry struct {
+		// Attempts 最多的尝试次数（包括首次请求）
+		Attempts int `json:"attempts"`
+		// Status 需要重试的响应状态码，默认为502 503
+		Status []int `json:"status"`
+		// Error 连接失败或超时时是否重试
+		Error bool `json:"error"`
+		// Timeout 每次请求的超时，为0则使用proxy的超时
+		Timeout time.Duration `json:"timeout"`
 	}
 	// Directors 用于director排序
 	Directors []*Director
-	// SelectFunc 用于选择排序的方法
-	SelectFunc func(*Context, *Director) uint32
+	// SelectFunc 用于选择排序的方法，backends为当前可用的backend列表，返回选择的下标
+	SelectFunc func(c *Context, d *Director, backends []string) uint32
 )
 
 const (
-	first            = "first"
-	random           = "random"
-	roundRobin       = "roundRobin"
-	ipHash           = "ipHash"
-	uriHash          = "uriHash"
-	headerHashPrefix = "header:"
-	cookieHashPrefix = "cookie:"
+	first      = "first"
+	random     = "random"
+	roundRobin = "roundRobin"
+	ipHash     = "ipHash"
+	uriHash    = "uriHash"
+	// HeaderHashPrefix 根据header选择backend的policy前缀，如"header:token"
+	HeaderHashPrefix = "header:"
+	// CookieHashPrefix 根据cookie选择backend的policy前缀，如"cookie:jt"
+	CookieHashPrefix = "cookie:"
 )
 
 var (
+	// selectLock 配置重新加载时会增加选择函数，因此需要加锁
+	selectLock          sync.RWMutex
 	selectFuncMap       = make(map[string]SelectFunc)
 	errNotSupportPolicy = errors.New("not support the policy")
 )
@@ -87,21 +140,30 @@ func hash(s string) uint32 {
 
 // AddSelect 增加select的处理函数
 func AddSelect(name string, fn SelectFunc) {
+	selectLock.Lock()
+	defer selectLock.Unlock()
 	selectFuncMap[name] = fn
 }
 
-// AddSelectByHeader 根据http header的字段来选择
+// getSelectFunc 获取policy对应的选择函数与一致性hash函数
+func getSelectFunc(policy string) (SelectFunc, HashFunc) {
+	selectLock.RLock()
+	defer selectLock.RUnlock()
+	return selectFuncMap[policy], hashFuncMap[policy]
+}
+
+// AddSelectByHeader 根据http header的字段来选择（一致性hash）
 func AddSelectByHeader(name, headerField string) {
-	fn := func(c *Context, d *Director) uint32 {
+	fn := func(c *Context) uint32 {
 		s := c.Request.Header.Get(headerField)
 		return hash(s)
 	}
-	AddSelect(name, fn)
+	AddHashSelect(name, fn)
 }
 
-// AddSelectByCookie 根据cookie来选择backend
+// AddSelectByCookie 根据cookie来选择backend（一致性hash）
 func AddSelectByCookie(name, cookieName string) {
-	fn := func(c *Context, d *Director) uint32 {
+	fn := func(c *Context) uint32 {
 		s := ""
 		cookie, _ := c.Request.Cookie(cookieName)
 		if cookie != nil {
@@ -109,56 +171,51 @@ func AddSelectByCookie(name, cookieName string) {
 		}
 		return hash(s)
 	}
-	AddSelect(name, fn)
+	AddHashSelect(name, fn)
 }
 
 func init() {
-	AddSelect(first, func(c *Context, d *Director) uint32 {
+	AddSelect(first, func(c *Context, d *Director, backends []string) uint32 {
 		return 0
 	})
-	AddSelect(random, func(c *Context, d *Director) uint32 {
-		return rand.Uint32()
-	})
-	AddSelect(roundRobin, func(c *Context, d *Director) uint32 {
-		return atomic.AddUint32(&d.roubin, 1)
-	})
-	AddSelect(ipHash, func(c *Context, d *Director) uint32 {
+	AddSelect(random, selectRandom)
+	AddSelect(roundRobin, selectRoundRobin)
+	AddSelect(weightedRoundRobin, selectWeightedRoundRobin)
+	AddSelect(leastConn, selectLeastConn)
+	AddSelect(ewma, selectEWMA)
+	AddSelect(sticky, selectSticky)
+	AddHashSelect(ipHash, func(c *Context) uint32 {
 		return hash(c.RealIP())
 	})
-	AddSelect(uriHash, func(c *Context, d *Director) uint32 {
+	AddHashSelect(uriHash, func(c *Context) uint32 {
 		return hash(c.Request.RequestURI)
 	})
 }
 
+// IsPolicyValid 判断是否支持该policy（不会增加选择函数）
+func IsPolicyValid(policy string) bool {
+	switch policy {
+	case "":
+		return true
+	case first, random, roundRobin, weightedRoundRobin, leastConn, ewma, sticky, ipHash, uriHash:
+		return true
+	}
+	return strings.HasPrefix(policy, HeaderHashPrefix) || strings.HasPrefix(policy, CookieHashPrefix)
+}
+
 // AddPolicySelectFunc 增加新的policy选择函数
 func AddPolicySelectFunc(policy string) (err error) {
-	if len(policy) == 0 {
-		return
+	if !IsPolicyValid(policy) {
+		return errNotSupportPolicy
 	}
-	switch policy {
-	case first:
-		break
-	case random:
-		break
-	case roundRobin:
-		break
-	case ipHash:
-		break
-	case uriHash:
-		break
-	default:
-		if strings.HasPrefix(policy, headerHashPrefix) {
-
-			header := policy[len(headerHashPrefix):]
-			// 增加自定义的header select function
-			AddSelectByHeader(policy, header)
-		} else if strings.HasPrefix(policy, cookieHashPrefix) {
-			cookie := policy[len(cookieHashPrefix):]
-			// 增加自定义的cookie select function
-			AddSelectByCookie(policy, cookie)
-		} else {
-			err = errNotSupportPolicy
-		}
+	if strings.HasPrefix(policy, HeaderHashPrefix) {
+		header := policy[len(HeaderHashPrefix):]
+		// 增加自定义的header select function
+		AddSelectByHeader(policy, header)
+	} else if strings.HasPrefix(policy, CookieHashPrefix) {
+		cookie := policy[len(CookieHashPrefix):]
+		// 增加自定义的cookie select function
+		AddSelectByCookie(policy, cookie)
 	}
 	return
 }
@@ -191,31 +248,104 @@ func (d *Director) RefreshPriority() {
 	d.Priority = priority
 }
 
-// AddBackend 增加backend
+// AddBackend 增加backend（可指定权重，如"http://127.0.0.1:3000 weight=3"），已存在则更新权重
 func (d *Director) AddBackend(backend string) {
-	backends := d.Backends
-	if !funk.ContainsString(backends, backend) {
+	backend, weight := ParseBackend(backend)
+	if len(backend) == 0 {
+		return
+	}
+	d.Lock()
+	defer d.Unlock()
+	if d.GetWeight(backend) != weight {
+		// 重新生成map，避免选择backend时并发读写
+		weights := make(map[string]int)
+		for k, v := range d.Weights {
+			weights[k] = v
+		}
+		if weight == defaultWeight {
+			delete(weights, backend)
+		} else {
+			weights[backend] = weight
+		}
+		if len(weights) == 0 {
+			weights = nil
+		}
+		d.Weights = weights
+		d.ring = nil
+	}
+	if !funk.ContainsString(d.Backends, backend) {
+		backends := make([]string, len(d.Backends), len(d.Backends)+1)
+		copy(backends, d.Backends)
 		d.Backends = append(backends, backend)
 	}
 }
 
-// RemoveBackend 删除backend
+// RemoveBackend 删除backend（同时从可用列表中删除）
 func (d *Director) RemoveBackend(backend string) {
-	backends := d.Backends
-
-	index := funk.IndexOfString(backends, backend)
-	if index != -1 {
-		d.Backends = append(backends[0:index], backends[index+1:]...)
+	d.Lock()
+	index := funk.IndexOfString(d.Backends, backend)
+	if index == -1 {
+		d.Unlock()
+		return
+	}
+	backends := make([]string, 0, len(d.Backends)-1)
+	backends = append(backends, d.Backends[:index]...)
+	d.Backends = append(backends, d.Backends[index+1:]...)
+	if _, ok := d.Weights[backend]; ok {
+		weights := make(map[string]int)
+		for k, v := range d.Weights {
+			if k != backend {
+				weights[k] = v
+			}
+		}
+		d.Weights = weights
+	}
+	if _, ok := d.HealthStatus[backend]; ok {
+		healthStatus := make(map[string]BackendHealth)
+		for k, v := range d.HealthStatus {
+			if k != backend {
+				healthStatus[k] = v
+			}
+		}
+		d.HealthStatus = healthStatus
+	}
+	if _, ok := d.BackendStates[backend]; ok {
+		states := make(map[string]string)
+		for k, v := range d.BackendStates {
+			if k != backend {
+				states[k] = v
+			}
+		}
+		d.BackendStates = states
 	}
+	d.Unlock()
+	d.RemoveAvailableBackend(backend)
+}
+
+// GetBackends 获取backend列表
+func (d *Director) GetBackends() []string {
+	d.RLock()
+	defer d.RUnlock()
+	return d.Backends
 }
 
 // AddAvailableBackend 增加可用backend列表
 func (d *Director) AddAvailableBackend(backend string) {
 	d.Lock()
 	defer d.Unlock()
-	backends := d.AvailableBackends
-	if !funk.ContainsString(backends, backend) {
+	if !funk.ContainsString(d.AvailableBackends, backend) {
+		backends := make([]string, len(d.AvailableBackends), len(d.AvailableBackends)+1)
+		copy(backends, d.AvailableBackends)
 		d.AvailableBackends = append(backends, backend)
+		// 记录加入的时间（用于slow start），并重置平滑加权轮询的当前权重
+		if d.availableAt == nil {
+			d.availableAt = make(map[string]time.Time)
+		}
+		d.availableAt[backend] = time.Now()
+		d.ring = nil
+		d.wrrLock.Lock()
+		delete(d.currentWeights, backend)
+		d.wrrLock.Unlock()
 	}
 }
 
@@ -223,11 +353,86 @@ func (d *Director) AddAvailableBackend(backend string) {
 func (d *Director) RemoveAvailableBackend(backend string) {
 	d.Lock()
 	defer d.Unlock()
-	backends := d.AvailableBackends
-	index := funk.IndexOfString(backends, backend)
+	index := funk.IndexOfString(d.AvailableBackends, backend)
 	if index != -1 {
-		d.AvailableBackends = append(backends[0:index], backends[index+1:]...)
+		// 重新生成slice，选择backend时不加锁读取原有的slice
+		backends := make([]string, 0, len(d.AvailableBackends)-1)
+		backends = append(backends, d.AvailableBackends[:index]...)
+		d.AvailableBackends = append(backends, d.AvailableBackends[index+1:]...)
+		delete(d.availableAt, backend)
+		d.ring = nil
+	}
+}
+
+// Inherit 继承原有director的backend可用状态、熔断状态与请求统计（重新加载配置时使用，避免等待health check）
+func (d *Director) Inherit(old *Director) {
+	available := old.GetAvailableBackends()
+	old.RLock()
+	availableAt := make(map[string]time.Time)
+	for k, v := range old.availableAt {
+		availableAt[k] = v
 	}
+	healthStatus := old.HealthStatus
+	backendStates := old.BackendStates
+	discovered := old.discovered
+	weights := old.Weights
+	old.RUnlock()
+
+	d.Lock()
+	// 继承通过discovery增加的backend（后续discovery的结果会再更新）
+	if d.Discovery != nil && len(discovered) != 0 {
+		d.discovered = make(map[string]bool)
+		for backend := range discovered {
+			if funk.ContainsString(d.Backends, backend) {
+				continue
+			}
+			d.Backends = append(d.Backends, backend)
+			d.discovered[backend] = true
+			if weight, ok := weights[backend]; ok {
+				if d.Weights == nil {
+					d.Weights = make(map[string]int)
+				}
+				d.Weights[backend] = weight
+			}
+		}
+	}
+	for _, backend := range d.Backends {
+		if !funk.ContainsString(available, backend) || funk.ContainsString(d.AvailableBackends, backend) {
+			continue
+		}
+		d.AvailableBackends = append(d.AvailableBackends, backend)
+		if d.availableAt == nil {
+			d.availableAt = make(map[string]time.Time)
+		}
+		if at, ok := availableAt[backend]; ok {
+			d.availableAt[backend] = at
+		}
+	}
+	if len(healthStatus) != 0 {
+		m := make(map[string]BackendHealth)
+		for _, backend := range d.Backends {
+			if h, ok := healthStatus[backend]; ok {
+				m[backend] = h
+			}
+		}
+		d.HealthStatus = m
+	}
+	if len(backendStates) != 0 {
+		m := make(map[string]string)
+		for _, backend := range d.Backends {
+			if state, ok := backendStates[backend]; ok {
+				m[backend] = state
+			}
+		}
+		d.BackendStates = m
+	}
+	d.ring = nil
+	backends := d.Backends
+	d.Unlock()
+
+	// 熔断状态与正在处理的请求数、响应时间按backend继承
+	d.inheritCircuits(old, backends)
+	d.inheritStats(old, backends)
 }
 
 // GetAvailableBackends 获取可用的backend
@@ -243,6 +448,7 @@ func (d *Director) AddHost(host string) {
 	if !funk.ContainsString(hosts, host) {
 		d.Hosts = append(hosts, host)
 		d.RefreshPriority()
+		d.GenHostRegexp()
 	}
 }
 
@@ -253,6 +459,7 @@ func (d *Director) RemoveHost(host string) {
 	if index != -1 {
 		d.Hosts = append(hosts[0:index], hosts[index+1:]...)
 		d.RefreshPriority()
+		d.GenHostRegexp()
 	}
 }
 
@@ -287,14 +494,10 @@ func (d *Director) Match(host, uri string) (match bool) {
 	}
 	// 判断host是否符合
 	if len(hosts) != 0 {
-		hostBytes := []byte(host)
-		for _, item := range hosts {
-			if match {
-				break
-			}
-			reg := regexp.MustCompile(item)
-			if reg.Match(hostBytes) {
+		for _, reg := range d.hostRegexps {
+			if reg.MatchString(host) {
 				match = true
+				break
 			}
 		}
 		// 如果host不匹配，直接返回
@@ -369,96 +572,95 @@ func (d *Director) GenHeaderMap() {
 
 // Prepare 调用生成、刷新配置
 func (d *Director) Prepare() {
+	d.ParseBackends()
 	d.RefreshPriority()
+	d.GenHostRegexp()
 	d.GenRewriteRegexp()
 	d.GenRequestHeaderMap()
 	d.GenHeaderMap()
 }
 
-// 检测url，如果5次有3次通过则认为是healthy
-func doCheck(url string) (healthy bool) {
-	var wg sync.WaitGroup
-	var successCount int32
-	p := &successCount
-	for i := 0; i < 5; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			client := http.Client{
-				Timeout: time.Duration(3 * time.Second),
-			}
-			resp, _ := client.Get(url)
-			if resp != nil {
-				statusCode := resp.StatusCode
-				if statusCode >= 200 && statusCode < 400 {
-					atomic.AddInt32(p, 1)
-				}
-			}
-		}()
-	}
-	wg.Wait()
-	if successCount >= 3 {
-		healthy = true
-	}
-	return
-}
-
 // Select 根据Policy选择一个backend
 func (d *Director) Select(c *Context) string {
 	policy := d.Policy
 	if len(policy) == 0 {
 		policy = roundRobin
 	}
-	fn := selectFuncMap[policy]
+	fn, hashFn := getSelectFunc(policy)
 	if fn == nil {
 		return ""
 	}
+	// 熔断的backend冷却时间已过，使用此请求试探
+	if backend := d.selectTrial(); len(backend) != 0 {
+		return backend
+	}
+	// sticky的请求优先使用cookie中记录的backend（draining的backend也可使用）
+	if policy == sticky {
+		if backend := d.getStickyBackend(c); len(backend) != 0 {
+			return backend
+		}
+	}
+	// hash的选择策略使用一致性hash环
+	if hashFn != nil {
+		return d.getHashRing().get(hashFn(c))
+	}
 	availableBackends := d.GetAvailableBackends()
 	count := uint32(len(availableBackends))
 	if count == 0 {
 		return ""
 	}
 
-	index := fn(c, d)
+	// 使用同一份可用列表选择，避免选择期间列表变化导致下标不一致
+	index := fn(c, d, availableBackends)
 
 	return availableBackends[index%count]
 }
 
-// HealthCheck 对director下的服务器做健康检测
-func (d *Director) HealthCheck() {
-	backends := d.Backends
-	for _, item := range backends {
-		go func(backend string) {
-			ping := d.Ping
-			if len(ping) == 0 {
-				ping = "/ping"
-			}
-			url := backend + ping
-			healthy := doCheck(url)
-			if healthy {
-				d.AddAvailableBackend(backend)
-			} else {
-				d.RemoveAvailableBackend(backend)
+// SelectNext 选择未尝试过的backend（用于重试），如果都已尝试则返回空
+func (d *Director) SelectNext(tried []string) string {
+	availableBackends := d.GetAvailableBackends()
+	count := len(availableBackends)
+	if count == 0 || len(tried) == 0 {
+		return ""
+	}
+	// 从最后尝试的backend的下一个开始选择
+	start := 0
+	last := tried[len(tried)-1]
+	for i, backend := range availableBackends {
+		if backend == last {
+			start = i + 1
+			break
+		}
+	}
+	for i := 0; i < count; i++ {
+		backend := availableBackends[(start+i)%count]
+		found := false
+		for _, item := range tried {
+			if item == backend {
+				found = true
+				break
 			}
-		}(item)
+		}
+		if !found {
+			return backend
+		}
 	}
+	return ""
 }
 
-// StartHealthCheck 启用health check
-func (d *Director) StartHealthCheck(interval time.Duration) {
-	defer func() {
-		if err := recover(); err != nil {
-			// 如果异常，等待后继续检测
-			log.Error("health check fail, ", err)
-			time.Sleep(time.Second)
-			d.StartHealthCheck(interval)
+// GenHostRegexp 生成host列表的正则（不合法的忽略）
+func (d *Director) GenHostRegexp() {
+	regs := make([]*regexp.Regexp, 0, len(d.Hosts))
+	for _, host := range d.Hosts {
+		reg, err := regexp.Compile(host)
+		if err != nil {
+			continue
 		}
-	}()
-	d.HealthCheck()
-	ticker := time.NewTicker(interval)
-	for _ = range ticker.C {
-		d.HealthCheck()
+		regs = append(regs, reg)
 	}
+	d.Lock()
+	defer d.Unlock()
+	d.hostRegexps = regs
 }
 
 // GenRewriteRegexp 生成重写url的正则
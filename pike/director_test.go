@@ -76,6 +76,24 @@ func TestDirector(t *testing.T) {
 		}
 	})
 
+	t.Run("host regexp", func(t *testing.T) {
+		d := &Director{
+			Name: "test",
+			Hosts: []string{
+				"(aslant.site",
+				"tiny.site",
+			},
+		}
+		d.Prepare()
+		if len(d.hostRegexps) != 1 {
+			t.Fatalf("the host regexps should be generated when prepare")
+		}
+		// 不合法的host忽略（不会panic）
+		if d.Match("aslant.site", "/") || !d.Match("tiny.site", "/") {
+			t.Fatalf("match by the host regexps fail")
+		}
+	})
+
 	t.Run("directors", func(t *testing.T) {
 		ds := make(Directors, 0)
 		ds = append(ds, &Director{
@@ -242,7 +260,7 @@ func TestSelect(t *testing.T) {
 		c := NewContext(httptest.NewRequest("GET", "/", nil))
 		for i := 0; i < 10; i++ {
 			backend := d.Select(c)
-			if backend != backends[0] {
+			if backend != backends[1] {
 				t.Fatalf("ipHash policy fail")
 			}
 		}
@@ -341,3 +359,157 @@ func TestDirectorPrepare(t *testing.T) {
 		t.Fatalf("gen priority fail")
 	}
 }
+
+func TestSelectNext(t *testing.T) {
+	d := &Director{
+		Name: "tiny",
+	}
+	d.AddAvailableBackend("http://127.0.0.1:5001")
+	d.AddAvailableBackend("http://127.0.0.1:5002")
+	d.AddAvailableBackend("http://127.0.0.1:5003")
+
+	if d.SelectNext([]string{"http://127.0.0.1:5002"}) != "http://127.0.0.1:5003" {
+		t.Fatalf("select next should use the next backend")
+	}
+	if d.SelectNext([]string{"http://127.0.0.1:5003", "http://127.0.0.1:5001"}) != "http://127.0.0.1:5002" {
+		t.Fatalf("select next should skip the tried backend")
+	}
+	if d.SelectNext(d.GetAvailableBackends()) != "" {
+		t.Fatalf("select next should return empty when all backends were tried")
+	}
+}
+
+func TestDirectorInherit(t *testing.T) {
+	old := &Director{
+		Name:     "tiny",
+		Backends: []string{"a", "b"},
+	}
+	old.AddAvailableBackend("a")
+	old.AddAvailableBackend("b")
+	old.updateHealth("a", nil)
+
+	d := &Director{
+		Name:     "tiny",
+		Backends: []string{"a", "c"},
+	}
+	d.Inherit(old)
+	available := d.GetAvailableBackends()
+	if len(available) != 1 || available[0] != "a" {
+		t.Fatalf("should inherit the available backend which still exists")
+	}
+	if !d.HealthStatus["a"].Healthy || d.availableAt["a"] != old.availableAt["a"] {
+		t.Fatalf("should inherit the health status and available time")
+	}
+
+	t.Run("circuit and stats", func(t *testing.T) {
+		old := &Director{
+			Name:     "tiny",
+			Backends: []string{"a", "b"},
+			CircuitBreaker: &CircuitBreaker{
+				Failures: 1,
+			},
+		}
+		old.AddAvailableBackend("a")
+		old.AddAvailableBackend("b")
+		old.Report("b", false)
+		old.BeginRequest("a")
+		old.BeginRequest("a")
+		old.EndRequest("a", 100*time.Millisecond)
+
+		d := &Director{
+			Name:     "tiny",
+			Backends: []string{"a", "b"},
+			CircuitBreaker: &CircuitBreaker{
+				Failures: 1,
+			},
+		}
+		d.Inherit(old)
+		if !d.IsCircuitOpen("b") || d.IsCircuitOpen("a") {
+			t.Fatalf("should inherit the circuit state")
+		}
+		if d.GetInflight("a") != 1 || d.getLatency("a") != float64(100*time.Millisecond) {
+			t.Fatalf("should inherit the inflight and latency")
+		}
+		// 原有director正在处理的请求完成后，新的director的统计也更新
+		old.EndRequest("a", 0)
+		if d.GetInflight("a") != 0 {
+			t.Fatalf("the inflight should be shared with the old director")
+		}
+		// 熔断状态为复制，原有director的后续变化不影响
+		old.Report("a", false)
+		if d.IsCircuitOpen("a") {
+			t.Fatalf("the circuit state should be copied")
+		}
+
+		// 未配置熔断则不继承熔断状态
+		d = &Director{
+			Name:     "tiny",
+			Backends: []string{"b"},
+		}
+		d.Inherit(old)
+		if d.IsCircuitOpen("b") {
+			t.Fatalf("should not inherit the circuit state without circuit breaker")
+		}
+	})
+}
+
+func TestStopHealthCheck(t *testing.T) {
+	d := &Director{
+		Name: "tiny",
+	}
+	done := make(chan bool)
+	go func() {
+		d.StartHealthCheck(time.Hour)
+		done <- true
+	}()
+	d.StopHealthCheck()
+	d.StopHealthCheck()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("the health check should be stopped")
+	}
+}
+
+func TestIsPolicyValid(t *testing.T) {
+	for _, policy := range []string{"", "first", "weightedRoundRobin", "sticky", "header:X-Token", "cookie:jt"} {
+		if !IsPolicyValid(policy) {
+			t.Fatalf("policy %q should be valid", policy)
+		}
+	}
+	if IsPolicyValid("abc") {
+		t.Fatalf("not support policy should be invalid")
+	}
+	// 校验policy不会增加选择函数
+	policy := "header:X-Validate"
+	IsPolicyValid(policy)
+	if fn, _ := getSelectFunc(policy); fn != nil {
+		t.Fatalf("validate policy should not add the select function")
+	}
+}
+
+func TestSelectFuncBackends(t *testing.T) {
+	policy := "test-select-backends"
+	var selectBackends []string
+	AddSelect(policy, func(c *Context, d *Director, backends []string) uint32 {
+		selectBackends = backends
+		return 1
+	})
+	defer func() {
+		selectLock.Lock()
+		delete(selectFuncMap, policy)
+		selectLock.Unlock()
+	}()
+	d := &Director{
+		Policy: policy,
+	}
+	d.AddAvailableBackend("a")
+	d.AddAvailableBackend("b")
+	c := NewContext(httptest.NewRequest(http.MethodGet, "/", nil))
+	if d.Select(c) != "b" {
+		t.Fatalf("should select the backend by the index")
+	}
+	if len(selectBackends) != 2 || selectBackends[0] != "a" || selectBackends[1] != "b" {
+		t.Fatalf("the available backends should be passed to the select function")
+	}
+}
@@ -0,0 +1,220 @@
+package pike
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// discoveryFile 从文件中读取backend列表（json或yaml的字符串数组）
+	discoveryFile = "file"
+	// discoveryDNS 定时解析域名的A或SRV记录生成backend列表
+	discoveryDNS = "dns"
+
+	defaultFileDiscoveryInterval = 5 * time.Second
+	defaultDNSDiscoveryInterval  = 30 * time.Second
+)
+
+var (
+	// ErrDiscoveryNotSupport 不支持的discovery类型
+	ErrDiscoveryNotSupport = errors.New("discovery type is not support")
+	// ErrDiscoveryHostInvalid dns discovery未配置域名
+	ErrDiscoveryHostInvalid = errors.New("discovery host can not be empty")
+	// ErrDiscoveryFileInvalid file discovery未配置文件
+	ErrDiscoveryFileInvalid = errors.New("discovery file can not be empty")
+)
+
+type (
+	// DiscoveryConfig backend自动发现的配置
+	DiscoveryConfig struct {
+		// Type 发现的方式（file dns）
+		Type string `json:"type"`
+		// File backend列表文件，格式为json或yaml的字符串数组，如["http://127.0.0.1:3000 weight=2"]
+		File string `json:"file,omitempty"`
+		// Host dns解析的域名，使用SRV记录时如_http._tcp.example.com
+		Host string `json:"host,omitempty"`
+		// SRV 是否解析SRV记录（使用记录的端口与权重）
+		SRV bool `json:"srv,omitempty"`
+		// Scheme dns解析生成backend时使用的协议，默认为http
+		Scheme string `json:"scheme,omitempty"`
+		// Port 解析A记录时backend的端口，默认为80
+		Port int `json:"port,omitempty"`
+		// Interval 更新的间隔，默认file为5s，dns为30s（file也是按间隔轮询，并不监听文件的变化）
+		Interval time.Duration `json:"interval,omitempty"`
+	}
+	// DiscoverFunc 获取backend列表的函数
+	DiscoverFunc func(conf *DiscoveryConfig) ([]string, error)
+)
+
+var discoverFuncMap = map[string]DiscoverFunc{
+	discoveryFile: discoverFile,
+	discoveryDNS:  discoverDNS,
+}
+
+// AddDiscoverFunc 增加自定义的discovery（需要在生成director之前添加）
+func AddDiscoverFunc(name string, fn DiscoverFunc) {
+	discoverFuncMap[name] = fn
+}
+
+// IsDiscoverySupported 判断是否支持该discovery类型
+func IsDiscoverySupported(name string) bool {
+	return discoverFuncMap[name] != nil
+}
+
+// discoverFile 读取文件中的backend列表（json是yaml的子集，统一使用yaml解析）
+func discoverFile(conf *DiscoveryConfig) ([]string, error) {
+	if len(conf.File) == 0 {
+		return nil, ErrDiscoveryFileInvalid
+	}
+	buf, err := ioutil.ReadFile(conf.File)
+	if err != nil {
+		return nil, err
+	}
+	backends := make([]string, 0)
+	err = yaml.Unmarshal(buf, &backends)
+	if err != nil {
+		return nil, err
+	}
+	return backends, nil
+}
+
+// discoverDNS 解析域名的A或SRV记录生成backend列表
+func discoverDNS(conf *DiscoveryConfig) ([]string, error) {
+	if len(conf.Host) == 0 {
+		return nil, ErrDiscoveryHostInvalid
+	}
+	scheme := conf.Scheme
+	if len(scheme) == 0 {
+		scheme = "http"
+	}
+	backends := make([]string, 0)
+	if conf.SRV {
+		_, addrs, err := net.LookupSRV("", "", conf.Host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			host := strings.TrimSuffix(addr.Target, ".")
+			backend := scheme + "://" + net.JoinHostPort(host, strconv.Itoa(int(addr.Port)))
+			if addr.Weight > 0 {
+				backend += " " + WeightOptionPrefix + strconv.Itoa(int(addr.Weight))
+			}
+			backends = append(backends, backend)
+		}
+		return backends, nil
+	}
+	port := conf.Port
+	if port <= 0 {
+		port = 80
+	}
+	ips, err := net.LookupHost(conf.Host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		backends = append(backends, scheme+"://"+net.JoinHostPort(ip, strconv.Itoa(port)))
+	}
+	return backends, nil
+}
+
+// Discover 获取backend列表并更新director的backend（配置文件中的backend不会被删除）
+func (d *Director) Discover() error {
+	conf := d.Discovery
+	if conf == nil {
+		return nil
+	}
+	fn := discoverFuncMap[conf.Type]
+	if fn == nil {
+		return ErrDiscoveryNotSupport
+	}
+	backends, err := fn(conf)
+	if err != nil {
+		return err
+	}
+	d.syncBackends(backends)
+	return nil
+}
+
+// syncBackends 根据discovery的结果增删backend
+func (d *Director) syncBackends(backends []string) {
+	d.RLock()
+	discovered := d.discovered
+	d.RUnlock()
+
+	current := make(map[string]bool)
+	added := make([]string, 0)
+	for _, item := range backends {
+		backend, _ := ParseBackend(item)
+		if len(backend) == 0 {
+			continue
+		}
+		exists := d.hasBackend(backend)
+		// 配置文件中的backend不使用discovery的配置（如权重）
+		if exists && !discovered[backend] {
+			continue
+		}
+		d.AddBackend(item)
+		current[backend] = true
+		if !exists {
+			added = append(added, backend)
+		}
+	}
+	for backend := range discovered {
+		if !current[backend] {
+			d.RemoveBackend(backend)
+		}
+	}
+	d.Lock()
+	d.discovered = current
+	d.Unlock()
+	// 已启用health check则立即检测新增的backend，未启用则由启用时的检测处理
+	if atomic.LoadInt32(&d.healthChecking) == 0 {
+		return
+	}
+	for _, backend := range added {
+		go d.checkBackend(backend)
+	}
+}
+
+// StartDiscovery 首次获取backend列表后定时更新，调用StopHealthCheck时停止
+func (d *Director) StartDiscovery() {
+	conf := d.Discovery
+	if conf == nil {
+		return
+	}
+	// 首次获取失败则使用从原有director继承的backend，等待下次更新
+	err := d.Discover()
+	if err != nil {
+		log.Error(d.Name, " discover backends fail, ", err)
+	}
+	interval := conf.Interval
+	if interval <= 0 {
+		interval = defaultDNSDiscoveryInterval
+		if conf.Type == discoveryFile {
+			interval = defaultFileDiscoveryInterval
+		}
+	}
+	stop := d.getHealthCheckStop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 获取失败（如dns解析失败）则保留原有的backend列表
+			err = d.Discover()
+			if err != nil {
+				log.Error(d.Name, " discover backends fail, ", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package pike
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDiscoverFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "pike-discovery")
+	if err != nil {
+		t.Fatalf("create temp file fail, %v", err)
+	}
+	file := f.Name()
+	f.Close()
+	defer os.Remove(file)
+
+	t.Run("json", func(t *testing.T) {
+		ioutil.WriteFile(file, []byte(`["http://127.0.0.1:5001", "http://127.0.0.1:5002 weight=2"]`), 0644)
+		backends, err := discoverFile(&DiscoveryConfig{
+			File: file,
+		})
+		if err != nil || len(backends) != 2 || backends[1] != "http://127.0.0.1:5002 weight=2" {
+			t.Fatalf("discover from json file fail, %v", err)
+		}
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		ioutil.WriteFile(file, []byte("- http://127.0.0.1:5001\n- http://127.0.0.1:5002\n"), 0644)
+		backends, err := discoverFile(&DiscoveryConfig{
+			File: file,
+		})
+		if err != nil || len(backends) != 2 || backends[0] != "http://127.0.0.1:5001" {
+			t.Fatalf("discover from yaml file fail, %v", err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := discoverFile(&DiscoveryConfig{}); err != ErrDiscoveryFileInvalid {
+			t.Fatalf("discover without file should return error")
+		}
+		ioutil.WriteFile(file, []byte(`{"a": 1}`), 0644)
+		if _, err := discoverFile(&DiscoveryConfig{File: file}); err == nil {
+			t.Fatalf("discover from invalid file should return error")
+		}
+	})
+}
+
+func TestDiscoverDNS(t *testing.T) {
+	if _, err := discoverDNS(&DiscoveryConfig{}); err != ErrDiscoveryHostInvalid {
+		t.Fatalf("discover without host should return error")
+	}
+	backends, err := discoverDNS(&DiscoveryConfig{
+		Host: "127.0.0.1",
+		Port: 3000,
+	})
+	if err != nil || len(backends) != 1 || backends[0] != "http://127.0.0.1:3000" {
+		t.Fatalf("discover from dns fail, %v", err)
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	backends := make([]string, 0)
+	AddDiscoverFunc("test", func(conf *DiscoveryConfig) ([]string, error) {
+		return backends, nil
+	})
+	defer delete(discoverFuncMap, "test")
+	d := &Director{
+		Backends: []string{
+			"http://127.0.0.1:5001",
+		},
+		Discovery: &DiscoveryConfig{
+			Type: "test",
+		},
+	}
+	d.AddAvailableBackend("http://127.0.0.1:5001")
+
+	t.Run("add backend", func(t *testing.T) {
+		backends = []string{
+			"http://127.0.0.1:5001",
+			"http://127.0.0.1:5002 weight=2",
+		}
+		err := d.Discover()
+		if err != nil {
+			t.Fatalf("discover fail, %v", err)
+		}
+		if len(d.GetBackends()) != 2 || d.Weights["http://127.0.0.1:5002"] != 2 {
+			t.Fatalf("should add the discovered backend")
+		}
+	})
+
+	t.Run("remove backend", func(t *testing.T) {
+		d.AddAvailableBackend("http://127.0.0.1:5002")
+		// 配置的backend不会因为discovery的结果而删除
+		backends = []string{}
+		err := d.Discover()
+		if err != nil {
+			t.Fatalf("discover fail, %v", err)
+		}
+		result := d.GetBackends()
+		if len(result) != 1 || result[0] != "http://127.0.0.1:5001" {
+			t.Fatalf("should remove the discovered backend only")
+		}
+		available := d.GetAvailableBackends()
+		if len(available) != 1 || len(d.Weights) != 0 {
+			t.Fatalf("the removed backend should not be available")
+		}
+	})
+
+	t.Run("inherit", func(t *testing.T) {
+		backends = []string{
+			"http://127.0.0.1:5002",
+		}
+		d.Discover()
+		d.AddAvailableBackend("http://127.0.0.1:5002")
+		nd := &Director{
+			Backends: []string{
+				"http://127.0.0.1:5001",
+			},
+			Discovery: d.Discovery,
+		}
+		nd.Inherit(d)
+		if len(nd.GetBackends()) != 2 || len(nd.GetAvailableBackends()) != 2 {
+			t.Fatalf("should inherit the discovered backend")
+		}
+		backends = []string{}
+		nd.Discover()
+		if len(nd.GetBackends()) != 1 {
+			t.Fatalf("the inherited backend should be removed by discovery")
+		}
+	})
+
+	t.Run("static backend", func(t *testing.T) {
+		d := &Director{
+			Backends: []string{
+				"http://127.0.0.1:5001 weight=3",
+			},
+			Discovery: &DiscoveryConfig{
+				Type: "test",
+			},
+		}
+		d.ParseBackends()
+		backends = []string{
+			"http://127.0.0.1:5001",
+		}
+		d.Discover()
+		if d.GetWeight("http://127.0.0.1:5001") != 3 {
+			t.Fatalf("the weight of static backend should not be reset by discovery")
+		}
+		backends = []string{}
+		d.Discover()
+		if len(d.GetBackends()) != 1 {
+			t.Fatalf("the static backend should not be removed by discovery")
+		}
+	})
+
+	t.Run("not support", func(t *testing.T) {
+		d := &Director{
+			Discovery: &DiscoveryConfig{
+				Type: "abc",
+			},
+		}
+		if d.Discover() != ErrDiscoveryNotSupport {
+			t.Fatalf("not support discovery should return error")
+		}
+	})
+}
+
+func TestDiscoverFailAfterInherit(t *testing.T) {
+	fail := false
+	AddDiscoverFunc("test-fail", func(conf *DiscoveryConfig) ([]string, error) {
+		if fail {
+			return nil, ErrDiscoveryHostInvalid
+		}
+		return []string{
+			"http://127.0.0.1:5002 weight=2",
+		}, nil
+	})
+	defer delete(discoverFuncMap, "test-fail")
+	newDirector := func() *Director {
+		return &Director{
+			Backends: []string{
+				"http://127.0.0.1:5001",
+			},
+			Discovery: &DiscoveryConfig{
+				Type: "test-fail",
+			},
+		}
+	}
+	old := newDirector()
+	old.Discover()
+	old.AddAvailableBackend("http://127.0.0.1:5002")
+
+	// 重新加载配置时discovery失败，保留原有discovery的backend
+	fail = true
+	d := newDirector()
+	d.Inherit(old)
+	if d.Discover() == nil {
+		t.Fatalf("discover should fail")
+	}
+	available := d.GetAvailableBackends()
+	if len(d.GetBackends()) != 2 || len(available) != 1 || available[0] != "http://127.0.0.1:5002" {
+		t.Fatalf("the discovered backends should be kept when discovery fail after reload")
+	}
+	if d.GetWeight("http://127.0.0.1:5002") != 2 {
+		t.Fatalf("the weight of discovered backend should be kept")
+	}
+	// 恢复后的discovery结果可删除继承的backend
+	fail = false
+	AddDiscoverFunc("test-fail", func(conf *DiscoveryConfig) ([]string, error) {
+		return []string{}, nil
+	})
+	d.Discover()
+	if len(d.GetBackends()) != 1 {
+		t.Fatalf("the inherited backend should be removed by the next discovery")
+	}
+}
+
+func TestStartDiscovery(t *testing.T) {
+	AddDiscoverFunc("test-start", func(conf *DiscoveryConfig) ([]string, error) {
+		return []string{
+			"http://127.0.0.1:5001",
+		}, nil
+	})
+	defer delete(discoverFuncMap, "test-start")
+	d := &Director{
+		Discovery: &DiscoveryConfig{
+			Type:     "test-start",
+			Interval: time.Hour,
+		},
+	}
+	done := make(chan bool)
+	go func() {
+		d.StartDiscovery()
+		done <- true
+	}()
+	// 启动时即获取backend列表，不需要等待定时更新
+	for i := 0; len(d.GetBackends()) == 0; i++ {
+		if i > 100 {
+			t.Fatalf("should discover the backends when start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	d.StopHealthCheck()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("the discovery should be stopped")
+	}
+}
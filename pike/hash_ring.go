@@ -0,0 +1,103 @@
+package pike
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+const (
+	// 每个backend（权重为1）在环上的节点数为 ketamaPoints * 4
+	ketamaPoints = 40
+)
+
+type (
+	// HashFunc 计算请求的hash值，使用一致性hash选择backend
+	HashFunc func(*Context) uint32
+	// hashRing 一致性hash环（ketama）
+	hashRing struct {
+		points   []uint32
+		backends map[uint32]string
+	}
+)
+
+var (
+	hashFuncMap = make(map[string]HashFunc)
+)
+
+// AddHashSelect 增加使用一致性hash选择backend的处理函数，
+// backend不可用时只有其对应的请求会重新分配
+func AddHashSelect(name string, fn HashFunc) {
+	selectLock.Lock()
+	hashFuncMap[name] = fn
+	selectLock.Unlock()
+	AddSelect(name, func(c *Context, d *Director, backends []string) uint32 {
+		return fn(c)
+	})
+}
+
+// newHashRing 根据backend列表与权重生成一致性hash环
+func newHashRing(backends []string, getWeight func(string) int) *hashRing {
+	r := &hashRing{
+		points:   make([]uint32, 0),
+		backends: make(map[uint32]string),
+	}
+	for _, backend := range backends {
+		count := ketamaPoints * getWeight(backend)
+		for i := 0; i < count; i++ {
+			digest := md5.Sum([]byte(backend + "-" + strconv.Itoa(i)))
+			for j := 0; j < 4; j++ {
+				point := binary.LittleEndian.Uint32(digest[j*4:])
+				// 冲突时保留先添加的backend
+				if _, ok := r.backends[point]; ok {
+					continue
+				}
+				r.backends[point] = backend
+				r.points = append(r.points, point)
+			}
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		return r.points[i] < r.points[j]
+	})
+	return r
+}
+
+// get 获取hash值在环上对应的backend（顺时针第一个节点）
+func (r *hashRing) get(value uint32) string {
+	count := len(r.points)
+	if count == 0 {
+		return ""
+	}
+	index := sort.Search(count, func(i int) bool {
+		return r.points[i] >= value
+	})
+	if index == count {
+		index = 0
+	}
+	return r.backends[r.points[index]]
+}
+
+// resetHashRing 重置一致性hash环（backend权重变化时）
+func (d *Director) resetHashRing() {
+	d.Lock()
+	defer d.Unlock()
+	d.ring = nil
+}
+
+// getHashRing 获取可用backend的一致性hash环，可用列表变化时重新生成
+func (d *Director) getHashRing() *hashRing {
+	d.RLock()
+	r := d.ring
+	d.RUnlock()
+	if r != nil {
+		return r
+	}
+	d.Lock()
+	defer d.Unlock()
+	if d.ring == nil {
+		d.ring = newHashRing(d.AvailableBackends, d.GetWeight)
+	}
+	return d.ring
+}
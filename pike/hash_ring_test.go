@@ -0,0 +1,77 @@
+package pike
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestHashRing(t *testing.T) {
+	getWeight := func(string) int {
+		return 1
+	}
+	r := newHashRing(nil, getWeight)
+	if r.get(1) != "" {
+		t.Fatalf("empty ring should return empty backend")
+	}
+	backends := []string{"a", "b", "c", "d"}
+	r = newHashRing(backends, getWeight)
+	if len(r.points) != 4*4*ketamaPoints {
+		t.Fatalf("the points of ring fail")
+	}
+	// 分布基本平均
+	result := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		result[r.get(hash(strconv.Itoa(i)))]++
+	}
+	for _, backend := range backends {
+		if result[backend] < 1500 || result[backend] > 3500 {
+			t.Fatalf("the distribution of ring is uneven, %v", result)
+		}
+	}
+
+	// 删除一个backend，只有其对应的key会重新分配
+	removed := newHashRing([]string{"a", "b", "d"}, getWeight)
+	for i := 0; i < 10000; i++ {
+		value := hash(strconv.Itoa(i))
+		prev := r.get(value)
+		current := removed.get(value)
+		if prev != "c" && prev != current {
+			t.Fatalf("the key of available backend should not be remapped")
+		}
+	}
+
+	// 权重
+	weighted := newHashRing([]string{"a", "b"}, func(backend string) int {
+		if backend == "a" {
+			return 3
+		}
+		return 1
+	})
+	result = make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		result[weighted.get(hash(strconv.Itoa(i)))]++
+	}
+	if result["a"] < 6500 || result["a"] > 8500 {
+		t.Fatalf("the weighted ring fail, %v", result)
+	}
+}
+
+func TestHashSelectRebuild(t *testing.T) {
+	d := &Director{
+		Policy: uriHash,
+	}
+	d.AddAvailableBackend("a")
+	d.AddAvailableBackend("b")
+	c := NewContext(httptest.NewRequest(http.MethodGet, "/users/me", nil))
+	backend := d.Select(c)
+	d.RemoveAvailableBackend(backend)
+	if d.Select(c) == backend {
+		t.Fatalf("the ring should be rebuilt after available backends changed")
+	}
+	d.AddAvailableBackend(backend)
+	if d.Select(c) != backend {
+		t.Fatalf("the key should be mapped back after backend available")
+	}
+}
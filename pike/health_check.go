@@ -0,0 +1,307 @@
+package pike
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHealthCheckTimeout = 3 * time.Second
+	defaultPing               = "/ping"
+	// 检测时最多读取的响应数据
+	maxHealthCheckBodySize = 1024 * 1024
+	// 未配置healthCheck时，每次检测并发请求的次数与需要成功的次数
+	defaultCheckProbes    = 5
+	defaultCheckSuccesses = 3
+)
+
+var (
+	errHealthCheckBodyNotMatch = errors.New("the response body not match")
+	errHealthCheckFail         = errors.New("the health check fail")
+)
+
+type (
+	// HealthCheckConfig 主动健康检测的配置
+	HealthCheckConfig struct {
+		// Interval 检测间隔，为0则使用StartHealthCheck的参数
+		Interval time.Duration `json:"interval"`
+		// Timeout 检测超时，默认为3s
+		Timeout time.Duration `json:"timeout"`
+		// Rise 不可用的backend连续成功多少次则为可用，默认为1
+		Rise int `json:"rise"`
+		// Fall 可用的backend连续失败多少次则为不可用，默认为1
+		Fall int `json:"fall"`
+		// Status 检测成功的响应状态码，默认为2xx与3xx
+		Status []int `json:"status"`
+		// Body 检测成功的响应数据需包含的字符串
+		Body string `json:"body"`
+		// Host 检测时请求的Host
+		Host string `json:"host"`
+		// TCP 只检测是否可以建立tcp连接
+		TCP bool `json:"tcp"`
+	}
+	// BackendHealth backend的健康检测状态
+	BackendHealth struct {
+		// Healthy 是否可用
+		Healthy bool `json:"healthy"`
+		// Success 最近一次检测是否成功
+		Success bool `json:"success"`
+		// Error 最近一次检测失败的原因
+		Error string `json:"error,omitempty"`
+		// CheckedAt 最近一次检测的时间
+		CheckedAt time.Time `json:"checkedAt"`
+		// 连续成功与失败的次数
+		successes int
+		failures  int
+	}
+)
+
+// getHealthCheckConfig 获取健康检测配置，未配置则使用默认值
+func (d *Director) getHealthCheckConfig() *HealthCheckConfig {
+	if d.HealthCheckConfig != nil {
+		return d.HealthCheckConfig
+	}
+	return &HealthCheckConfig{}
+}
+
+// doCheck 检测backend是否可用，未配置healthCheck时并发检测多次，成功次数足够则为可用
+func (d *Director) doCheck(backend string) error {
+	conf := d.HealthCheckConfig
+	if conf != nil {
+		return d.probe(backend, conf)
+	}
+	conf = &HealthCheckConfig{}
+	var wg sync.WaitGroup
+	var successes int32
+	var lastErr atomic.Value
+	for i := 0; i < defaultCheckProbes; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := d.probe(backend, conf)
+			if err != nil {
+				lastErr.Store(err)
+				return
+			}
+			atomic.AddInt32(&successes, 1)
+		}()
+	}
+	wg.Wait()
+	if successes >= defaultCheckSuccesses {
+		return nil
+	}
+	if err, ok := lastErr.Load().(error); ok {
+		return err
+	}
+	return errHealthCheckFail
+}
+
+// probe 按配置检测一次backend
+func (d *Director) probe(backend string, conf *HealthCheckConfig) error {
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthCheckTimeout
+	}
+	if conf.TCP {
+		return checkTCP(backend, timeout)
+	}
+	ping := d.Ping
+	if len(ping) == 0 {
+		ping = defaultPing
+	}
+	req, err := http.NewRequest(http.MethodGet, backend+ping, nil)
+	if err != nil {
+		return err
+	}
+	if len(conf.Host) != 0 {
+		req.Host = conf.Host
+	}
+	client := http.Client{
+		Timeout: timeout,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	statusCode := resp.StatusCode
+	if len(conf.Status) != 0 {
+		matched := false
+		for _, v := range conf.Status {
+			if v == statusCode {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("the status %d not match", statusCode)
+		}
+	} else if statusCode < 200 || statusCode >= 400 {
+		return fmt.Errorf("the status %d is not 2xx or 3xx", statusCode)
+	}
+	if len(conf.Body) != 0 {
+		buf, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBodySize))
+		if err != nil {
+			return err
+		}
+		if !strings.Contains(string(buf), conf.Body) {
+			return errHealthCheckBodyNotMatch
+		}
+	}
+	return nil
+}
+
+// checkTCP 检测backend是否可以建立tcp连接
+func checkTCP(backend string, timeout time.Duration) error {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return err
+	}
+	host := u.Host
+	if len(u.Port()) == 0 {
+		if u.Scheme == "https" {
+			host += ":443"
+		} else {
+			host += ":80"
+		}
+	}
+	conn, err := net.DialTimeout("tcp", host, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// updateHealth 根据检测结果（连续成功或失败的次数）更新backend的状态
+func (d *Director) updateHealth(backend string, err error) BackendHealth {
+	conf := d.getHealthCheckConfig()
+	rise := conf.Rise
+	if rise <= 0 {
+		rise = 1
+	}
+	fall := conf.Fall
+	if fall <= 0 {
+		fall = 1
+	}
+	d.Lock()
+	defer d.Unlock()
+	h, found := d.HealthStatus[backend]
+	h.CheckedAt = time.Now()
+	h.Success = err == nil
+	if err == nil {
+		h.Error = ""
+		h.successes++
+		h.failures = 0
+	} else {
+		h.Error = err.Error()
+		h.failures++
+		h.successes = 0
+	}
+	// 首次检测直接使用检测结果
+	if !found {
+		h.Healthy = h.Success
+	} else if !h.Healthy && h.successes >= rise {
+		h.Healthy = true
+	} else if h.Healthy && h.failures >= fall {
+		h.Healthy = false
+	}
+	// 重新生成map，避免输出时并发读写
+	m := make(map[string]BackendHealth, len(d.HealthStatus)+1)
+	for k, v := range d.HealthStatus {
+		m[k] = v
+	}
+	m[backend] = h
+	d.HealthStatus = m
+	return h
+}
+
+// HealthCheck 对director下的服务器做健康检测
+func (d *Director) HealthCheck() {
+	for _, item := range d.GetBackends() {
+		go d.checkBackend(item)
+	}
+}
+
+// checkBackend 检测backend并根据结果更新可用列表
+func (d *Director) checkBackend(backend string) {
+	err := d.doCheck(backend)
+	// 检测期间backend已删除（如discovery更新了backend列表）
+	if !d.hasBackend(backend) {
+		return
+	}
+	h := d.updateHealth(backend, err)
+	// 已熔断的backend由试探请求的结果决定是否恢复，draining与disabled的backend不加入可用列表
+	if h.Healthy && !d.IsCircuitOpen(backend) && d.isBackendEnabled(backend) {
+		d.AddAvailableBackend(backend)
+	} else if !h.Healthy {
+		d.RemoveAvailableBackend(backend)
+	}
+}
+
+// isBackendHealthy 判断backend是否健康（未有检测结果的视为健康）
+func (d *Director) isBackendHealthy(backend string) bool {
+	d.RLock()
+	defer d.RUnlock()
+	h, ok := d.HealthStatus[backend]
+	return !ok || h.Healthy
+}
+
+// StartHealthCheck 启用health check
+func (d *Director) StartHealthCheck(interval time.Duration) {
+	defer func() {
+		if err := recover(); err != nil {
+			// 如果异常，等待后继续检测
+			log.Error("health check fail, ", err)
+			time.Sleep(time.Second)
+			d.StartHealthCheck(interval)
+		}
+	}()
+	if conf := d.HealthCheckConfig; conf != nil && conf.Interval > 0 {
+		interval = conf.Interval
+	}
+	stop := d.getHealthCheckStop()
+	atomic.StoreInt32(&d.healthChecking, 1)
+	d.HealthCheck()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			d.HealthCheck()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// getHealthCheckStop 获取停止health check的channel
+func (d *Director) getHealthCheckStop() chan struct{} {
+	d.Lock()
+	defer d.Unlock()
+	if d.healthCheckStop == nil {
+		d.healthCheckStop = make(chan struct{})
+	}
+	return d.healthCheckStop
+}
+
+// StopHealthCheck 停止health check与discovery（director不再使用时）
+func (d *Director) StopHealthCheck() {
+	stop := d.getHealthCheckStop()
+	d.Lock()
+	defer d.Unlock()
+	if !d.healthCheckStopped {
+		d.healthCheckStopped = true
+		close(stop)
+	}
+}
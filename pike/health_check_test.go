@@ -0,0 +1,96 @@
+package pike
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDoCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Host != "aslant.site" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("pong"))
+	}))
+	defer server.Close()
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	closed.Close()
+
+	d := &Director{
+		Name: "tiny",
+	}
+	t.Run("default", func(t *testing.T) {
+		if d.doCheck(server.URL) == nil {
+			t.Fatalf("404 should be check fail")
+		}
+		if d.doCheck(closed.URL) == nil {
+			t.Fatalf("closed server should be check fail")
+		}
+	})
+
+	t.Run("host status and body", func(t *testing.T) {
+		d.HealthCheckConfig = &HealthCheckConfig{
+			Host:   "aslant.site",
+			Status: []int{http.StatusAccepted},
+			Body:   "pong",
+		}
+		if err := d.doCheck(server.URL); err != nil {
+			t.Fatalf("check fail, %v", err)
+		}
+		d.HealthCheckConfig.Status = []int{http.StatusOK}
+		if d.doCheck(server.URL) == nil {
+			t.Fatalf("status not match should be check fail")
+		}
+		d.HealthCheckConfig.Status = nil
+		d.HealthCheckConfig.Body = "ok"
+		if d.doCheck(server.URL) != errHealthCheckBodyNotMatch {
+			t.Fatalf("body not match should be check fail")
+		}
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		d.HealthCheckConfig = &HealthCheckConfig{
+			TCP: true,
+		}
+		if err := d.doCheck(server.URL); err != nil {
+			t.Fatalf("tcp check fail, %v", err)
+		}
+		if d.doCheck(closed.URL) == nil {
+			t.Fatalf("tcp check closed server should be fail")
+		}
+	})
+}
+
+func TestUpdateHealth(t *testing.T) {
+	backend := "http://127.0.0.1:5001"
+	d := &Director{
+		Name: "tiny",
+		HealthCheckConfig: &HealthCheckConfig{
+			Rise: 2,
+			Fall: 2,
+		},
+	}
+	if !d.updateHealth(backend, nil).Healthy {
+		t.Fatalf("the first check should use the result")
+	}
+	h := d.updateHealth(backend, errHealthCheckBodyNotMatch)
+	if !h.Healthy || h.Success || h.Error != errHealthCheckBodyNotMatch.Error() {
+		t.Fatalf("the backend should be healthy until fall count")
+	}
+	if d.updateHealth(backend, errHealthCheckBodyNotMatch).Healthy {
+		t.Fatalf("the backend should be sick after fall count")
+	}
+	if d.updateHealth(backend, nil).Healthy {
+		t.Fatalf("the backend should be sick until rise count")
+	}
+	h = d.updateHealth(backend, nil)
+	if !h.Healthy || !h.Success || h.CheckedAt.IsZero() {
+		t.Fatalf("the backend should be healthy after rise count")
+	}
+	if !d.HealthStatus[backend].Healthy {
+		t.Fatalf("the health status should be recorded")
+	}
+}
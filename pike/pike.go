@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -46,6 +47,8 @@ const (
 	HeaderAge = "Age"
 	// HeaderAcceptEncoding http accept-encoding header
 	HeaderAcceptEncoding = "Accept-Encoding"
+	// HeaderVary http vary header
+	HeaderVary = "Vary"
 	// HeaderXStatus http x-status response header
 	HeaderXStatus = "X-Status"
 	// GzipEncoding gzip encoding
@@ -73,7 +76,8 @@ var (
 type (
 	// Pike app instance of pike
 	Pike struct {
-		middleware         []Middleware
+		// middleware 中间件列表（[]Middleware，配置重新加载时整体替换）
+		middleware         atomic.Value
 		server             *http.Server
 		ReadTimeout        time.Duration
 		WriteTimeout       time.Duration
@@ -126,16 +130,36 @@ func New() (p *Pike) {
 	return
 }
 
+// getMiddleware 获取当前的中间件列表
+func (p *Pike) getMiddleware() []Middleware {
+	mids, _ := p.middleware.Load().([]Middleware)
+	return mids
+}
+
 // Use add middleware function
 func (p *Pike) Use(mids ...Middleware) {
-	p.middleware = append(p.middleware, mids...)
+	current := p.getMiddleware()
+	result := make([]Middleware, 0, len(current)+len(mids))
+	result = append(result, current...)
+	p.middleware.Store(append(result, mids...))
+}
+
+// SetMiddleware 替换所有的中间件（用于重新加载配置），正在处理的请求不受影响
+func (p *Pike) SetMiddleware(mids ...Middleware) {
+	result := make([]Middleware, len(mids))
+	copy(result, mids)
+	p.middleware.Store(result)
 }
 
 // handle http server handler function
 func (p *Pike) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	mids := p.middleware
+	mids := p.getMiddleware()
 	c := NewContext(r)
 	defer func() {
+		// 如果直接转发的数据未读取完成，需要关闭，避免backend的请求未释放
+		if c.Stream != nil {
+			c.Stream.Close()
+		}
 		c.Request = nil
 		c.ResponseWriter = nil
 		contextPool.Put(c)
@@ -51,6 +51,23 @@ func TestPike(t *testing.T) {
 		}
 	})
 
+	t.Run("set middleware", func(t *testing.T) {
+		p := New()
+		p.Use(func(c *Context, next Next) error {
+			c.Response.Write([]byte("old"))
+			return next()
+		})
+		p.SetMiddleware(func(c *Context, next Next) error {
+			c.Response.Write([]byte("new"))
+			return next()
+		})
+		w := httptest.NewRecorder()
+		p.ServeHTTP(w, &http.Request{})
+		if w.Body.String() != "new" {
+			t.Fatalf("the middleware should be replaced")
+		}
+	})
+
 	t.Run("error handler", func(t *testing.T) {
 		p := New()
 		catchError := false
@@ -2,6 +2,7 @@ package pike
 
 import (
 	"bytes"
+	"io"
 	"net/http"
 )
 
@@ -12,6 +13,8 @@ type (
 		headers   http.Header
 		code      int
 		Committed bool
+		// 直接写至ResponseWriter的数据大小（stream）
+		streamSize int
 	}
 )
 
@@ -46,7 +49,40 @@ func (w *Response) Status() int {
 
 // Size get the response size
 func (w *Response) Size() int {
-	return w.body.Len()
+	return w.body.Len() + w.streamSize
+}
+
+// Stream 将响应头与数据直接写至ResponseWriter（数据不缓存至body），用于大数据的转发
+func (w *Response) Stream(rw http.ResponseWriter, r io.Reader) error {
+	w.Committed = true
+	header := rw.Header()
+	for field, values := range w.headers {
+		for _, value := range values {
+			header.Add(field, value)
+		}
+	}
+	rw.WriteHeader(w.code)
+	flusher, _ := rw.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			_, writeErr := rw.Write(buf[:n])
+			if writeErr != nil {
+				return writeErr
+			}
+			w.streamSize += n
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
 }
 
 // Reset reset the response sturct
@@ -54,6 +90,7 @@ func (w *Response) Reset() {
 	w.body.Reset()
 	w.code = http.StatusNotFound
 	w.Committed = false
+	w.streamSize = 0
 	for k := range w.headers {
 		delete(w.headers, k)
 	}
@@ -1,7 +1,9 @@
 package pike
 
 import (
+	"bytes"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -37,3 +39,21 @@ func TestResponse(t *testing.T) {
 	}
 
 }
+
+func TestResponseStream(t *testing.T) {
+	resp := NewResponse()
+	resp.WriteHeader(http.StatusOK)
+	resp.Header().Set("A", "B")
+	w := httptest.NewRecorder()
+	data := bytes.Repeat([]byte("ABCD"), 20*1024)
+	err := resp.Stream(w, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("stream response fail, %v", err)
+	}
+	if !resp.Committed || resp.Size() != len(data) {
+		t.Fatalf("the response should be committed after stream")
+	}
+	if w.Code != http.StatusOK || w.Header().Get("A") != "B" || !bytes.Equal(w.Body.Bytes(), data) {
+		t.Fatalf("stream response data fail")
+	}
+}
@@ -52,6 +52,8 @@ type (
 		startedAt     int64
 		startedAtList []int64
 		useList       []int64
+		// proxy重试的次数
+		retries int
 	}
 )
 
@@ -70,6 +72,7 @@ func (st *ServerTiming) Reset() {
 	for i := range useList {
 		useList[i] = 0
 	}
+	st.retries = 0
 	st.startedAt = time.Now().UnixNano()
 }
 
@@ -84,6 +87,11 @@ func (st *ServerTiming) Start(index int) func() {
 	}
 }
 
+// SetRetries 设置proxy重试的次数
+func (st *ServerTiming) SetRetries(retries int) {
+	st.retries = retries
+}
+
 // String 获取server timing的http header string
 func (st *ServerTiming) String() string {
 	if st.disabled {
@@ -104,5 +112,8 @@ func (st *ServerTiming) String() string {
 			appendDesc(v, serverTimingDesList[i])
 		}
 	}
+	if st.retries != 0 {
+		desList = append(desList, fmt.Sprintf("retry;desc=\"%d\"", st.retries))
+	}
 	return strings.Join(desList, ",")
 }
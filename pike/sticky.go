@@ -0,0 +1,168 @@
+package pike
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vicanso/pike/util"
+)
+
+const (
+	// sticky 由pike设置cookie（签名）记录选择的backend，之后的请求使用相同的backend
+	sticky             = "sticky"
+	defaultStickyName  = "pike_sticky"
+	defaultStickyPath  = "/"
+	stickyIDLength     = 16
+	stickySecretLength = 32
+)
+
+type (
+	// StickyConfig sticky session的cookie配置
+	StickyConfig struct {
+		// Cookie cookie的名称，默认为pike_sticky
+		Cookie string `json:"cookie"`
+		// Secret cookie签名的密钥（支持${ENV}的形式），多个pike实例需要配置相同的值，
+		// 未配置则启动时随机生成（重新加载配置时不变）
+		Secret string `json:"-"`
+		// MaxAge cookie的有效期，为0则为session cookie
+		MaxAge time.Duration `json:"maxAge"`
+		// Path cookie的path，默认为/
+		Path string `json:"path"`
+	}
+)
+
+var (
+	defaultStickySecretOnce sync.Once
+	defaultStickySecret     string
+)
+
+// getDefaultStickySecret 获取未配置密钥时使用的随机密钥，每个进程只生成一次，
+// 重新加载配置（重新生成director）后原有的cookie仍有效
+func getDefaultStickySecret() string {
+	defaultStickySecretOnce.Do(func() {
+		buf := make([]byte, stickySecretLength)
+		rand.Read(buf)
+		defaultStickySecret = string(buf)
+	})
+	return defaultStickySecret
+}
+
+// getStickyConfig 获取sticky的配置，未配置则使用默认值
+func (d *Director) getStickyConfig() *StickyConfig {
+	d.stickyOnce.Do(func() {
+		conf := StickyConfig{}
+		if d.StickyConfig != nil {
+			conf = *d.StickyConfig
+		}
+		if len(conf.Cookie) == 0 {
+			conf.Cookie = defaultStickyName
+		}
+		if len(conf.Path) == 0 {
+			conf.Path = defaultStickyPath
+		}
+		// ${ENV}形式的密钥从env中获取（env未设置则随机生成）
+		if strings.HasPrefix(conf.Secret, "${") {
+			conf.Secret = util.CheckAndGetValueFromEnv(conf.Secret)
+		}
+		if len(conf.Secret) == 0 {
+			conf.Secret = getDefaultStickySecret()
+		}
+		d.stickyConf = &conf
+	})
+	return d.stickyConf
+}
+
+// getStickyID 获取backend的标识（cookie中不暴露backend的地址）
+func getStickyID(backend string) string {
+	sum := md5.Sum([]byte(backend))
+	return hex.EncodeToString(sum[:])[:stickyIDLength]
+}
+
+// signSticky 对backend的标识签名
+func signSticky(secret, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// getStickyBackend 获取cookie中记录的backend，签名不正确或backend不可用则返回空
+// （draining的backend如果健康检测正常仍可使用，使已有的会话可以完成）
+func (d *Director) getStickyBackend(c *Context) string {
+	if c.Request == nil {
+		return ""
+	}
+	conf := d.getStickyConfig()
+	cookie, err := c.Request.Cookie(conf.Cookie)
+	if err != nil {
+		return ""
+	}
+	arr := strings.SplitN(cookie.Value, ".", 2)
+	if len(arr) != 2 {
+		return ""
+	}
+	id := arr[0]
+	if !hmac.Equal([]byte(arr[1]), []byte(signSticky(conf.Secret, id))) {
+		return ""
+	}
+	for _, backend := range d.GetAvailableBackends() {
+		if getStickyID(backend) == id {
+			return backend
+		}
+	}
+	d.RLock()
+	states := d.BackendStates
+	healthStatus := d.HealthStatus
+	d.RUnlock()
+	for backend, state := range states {
+		if state != BackendDraining || getStickyID(backend) != id {
+			continue
+		}
+		if h, ok := healthStatus[backend]; (!ok || h.Healthy) && !d.IsCircuitOpen(backend) {
+			return backend
+		}
+	}
+	return ""
+}
+
+// selectSticky 优先使用cookie中记录的backend，如果不可用则轮询选择
+func selectSticky(c *Context, d *Director, backends []string) uint32 {
+	backend := d.getStickyBackend(c)
+	if len(backend) != 0 {
+		for i, item := range backends {
+			if item == backend {
+				return uint32(i)
+			}
+		}
+	}
+	return selectRoundRobin(c, d, backends)
+}
+
+// GetStickyCookie 获取需要设置的sticky cookie，如果请求的cookie已记录此backend则返回nil
+func (d *Director) GetStickyCookie(c *Context, backend string) *http.Cookie {
+	if d.Policy != sticky || len(backend) == 0 {
+		return nil
+	}
+	if d.getStickyBackend(c) == backend {
+		return nil
+	}
+	conf := d.getStickyConfig()
+	id := getStickyID(backend)
+	cookie := &http.Cookie{
+		Name:     conf.Cookie,
+		Value:    id + "." + signSticky(conf.Secret, id),
+		Path:     conf.Path,
+		HttpOnly: true,
+	}
+	if conf.MaxAge > 0 {
+		cookie.MaxAge = int(conf.MaxAge.Seconds())
+	}
+	return cookie
+}
@@ -0,0 +1,110 @@
+package pike
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSticky(t *testing.T) {
+	d := &Director{
+		Policy: sticky,
+		StickyConfig: &StickyConfig{
+			Secret: "secret",
+			MaxAge: time.Hour,
+		},
+	}
+	d.AddAvailableBackend("http://127.0.0.1:5001")
+	d.AddAvailableBackend("http://127.0.0.1:5002")
+
+	newContext := func(cookie *http.Cookie) *Context {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		return NewContext(req)
+	}
+
+	t.Run("set cookie for new client", func(t *testing.T) {
+		c := newContext(nil)
+		backend := d.Select(c)
+		cookie := d.GetStickyCookie(c, backend)
+		if cookie == nil || cookie.Name != defaultStickyName || cookie.MaxAge != 3600 || cookie.Path != "/" {
+			t.Fatalf("should set sticky cookie for new client")
+		}
+		// 后续的请求都使用相同的backend
+		c = newContext(cookie)
+		for i := 0; i < 5; i++ {
+			if d.Select(c) != backend {
+				t.Fatalf("should select the sticky backend")
+			}
+		}
+		if d.GetStickyCookie(c, backend) != nil {
+			t.Fatalf("should not set cookie again")
+		}
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		backend := "http://127.0.0.1:5002"
+		cookie := &http.Cookie{
+			Name:  defaultStickyName,
+			Value: getStickyID(backend) + ".invalid",
+		}
+		c := newContext(cookie)
+		if d.getStickyBackend(c) != "" {
+			t.Fatalf("the cookie with invalid signature should be ignored")
+		}
+		if d.GetStickyCookie(c, backend) == nil {
+			t.Fatalf("should reset the cookie with invalid signature")
+		}
+	})
+
+	t.Run("fail over", func(t *testing.T) {
+		c := newContext(nil)
+		backend := d.Select(c)
+		c = newContext(d.GetStickyCookie(c, backend))
+		d.RemoveAvailableBackend(backend)
+		other := d.Select(c)
+		if other == backend || other == "" {
+			t.Fatalf("should select other backend when the sticky backend is unavailable")
+		}
+		if d.GetStickyCookie(c, other) == nil {
+			t.Fatalf("should set cookie for the new backend")
+		}
+		d.AddAvailableBackend(backend)
+	})
+
+	t.Run("reload", func(t *testing.T) {
+		newDirector := func() *Director {
+			d := &Director{
+				Policy: sticky,
+				Backends: []string{
+					"http://127.0.0.1:5001",
+					"http://127.0.0.1:5002",
+				},
+			}
+			for _, backend := range d.Backends {
+				d.AddAvailableBackend(backend)
+			}
+			return d
+		}
+		// 未配置密钥，重新加载配置后原有的cookie仍有效
+		old := newDirector()
+		backend := old.Select(newContext(nil))
+		cookie := old.GetStickyCookie(newContext(nil), backend)
+		d := newDirector()
+		d.Inherit(old)
+		c := newContext(cookie)
+		if d.getStickyBackend(c) != backend || d.GetStickyCookie(c, backend) != nil {
+			t.Fatalf("the cookie signed before reload should be valid")
+		}
+	})
+
+	t.Run("not sticky policy", func(t *testing.T) {
+		d := &Director{}
+		if d.GetStickyCookie(newContext(nil), "http://127.0.0.1:5001") != nil {
+			t.Fatalf("should not set cookie when policy is not sticky")
+		}
+	})
+}
@@ -0,0 +1,167 @@
+package pike
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// weightedRoundRobin 平滑的加权轮询（与nginx一致）
+	weightedRoundRobin = "weightedRoundRobin"
+	defaultWeight      = 1
+	// WeightOptionPrefix backend权重配置的前缀，如"http://127.0.0.1:3000 weight=3"
+	WeightOptionPrefix = "weight="
+)
+
+// ParseBackend 解析backend的配置，如"http://127.0.0.1:3000 weight=3"
+func ParseBackend(value string) (backend string, weight int) {
+	weight = defaultWeight
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return
+	}
+	backend = fields[0]
+	for _, field := range fields[1:] {
+		if !strings.HasPrefix(field, WeightOptionPrefix) {
+			continue
+		}
+		v, err := strconv.Atoi(field[len(WeightOptionPrefix):])
+		if err == nil && v > 0 {
+			weight = v
+		}
+	}
+	return
+}
+
+// ParseBackends 解析backend列表的配置，生成backend与权重
+func (d *Director) ParseBackends() {
+	backends := make([]string, 0, len(d.Backends))
+	weights := make(map[string]int)
+	for _, item := range d.Backends {
+		backend, weight := ParseBackend(item)
+		if len(backend) == 0 {
+			continue
+		}
+		backends = append(backends, backend)
+		if weight != defaultWeight {
+			weights[backend] = weight
+		}
+	}
+	d.Backends = backends
+	if len(weights) != 0 {
+		d.Weights = weights
+	} else {
+		d.Weights = nil
+	}
+	d.resetHashRing()
+}
+
+// isWeighted 是否需要按权重选择backend
+func (d *Director) isWeighted() bool {
+	d.RLock()
+	defer d.RUnlock()
+	return len(d.Weights) != 0 || d.SlowStart > 0
+}
+
+// GetWeight 获取backend的权重（backend可能动态增删，调用时需持有director的锁）
+func (d *Director) GetWeight(backend string) int {
+	weight := d.Weights[backend]
+	if weight <= 0 {
+		return defaultWeight
+	}
+	return weight
+}
+
+// getEffectiveWeights 获取backend当前的权重，刚恢复可用的backend在slow start期间权重逐步增加
+func (d *Director) getEffectiveWeights(backends []string) (weights []float64, total float64) {
+	weights = make([]float64, len(backends))
+	now := time.Now()
+	d.RLock()
+	defer d.RUnlock()
+	for i, backend := range backends {
+		weight := float64(d.GetWeight(backend))
+		if d.SlowStart > 0 {
+			if availableAt, ok := d.availableAt[backend]; ok {
+				elapsed := now.Sub(availableAt)
+				if elapsed < d.SlowStart {
+					// 最少保留1%的权重，避免完全没有请求
+					weight *= math.Max(float64(elapsed)/float64(d.SlowStart), 0.01)
+				}
+			}
+		}
+		weights[i] = weight
+		total += weight
+	}
+	return
+}
+
+// getWeightedIndex 根据权重位置获取对应的backend
+func getWeightedIndex(weights []float64, pos float64) uint32 {
+	for i, weight := range weights {
+		if pos < weight {
+			return uint32(i)
+		}
+		pos -= weight
+	}
+	return uint32(len(weights) - 1)
+}
+
+// selectRandom 随机选择backend，有配置权重则按权重随机
+func selectRandom(c *Context, d *Director, backends []string) uint32 {
+	if !d.isWeighted() {
+		return rand.Uint32()
+	}
+	weights, total := d.getEffectiveWeights(backends)
+	if len(weights) == 0 {
+		return 0
+	}
+	return getWeightedIndex(weights, rand.Float64()*total)
+}
+
+// selectRoundRobin 轮询选择backend，有配置权重则每轮按权重（向上取整）选择
+func selectRoundRobin(c *Context, d *Director, backends []string) uint32 {
+	count := atomic.AddUint32(&d.roubin, 1)
+	if !d.isWeighted() {
+		return count
+	}
+	weights, _ := d.getEffectiveWeights(backends)
+	if len(weights) == 0 {
+		return 0
+	}
+	total := 0.0
+	for i, weight := range weights {
+		weights[i] = math.Ceil(weight)
+		total += weights[i]
+	}
+	return getWeightedIndex(weights, float64(count%uint32(total)))
+}
+
+// selectWeightedRoundRobin 平滑的加权轮询，每次选择当前权重最大的backend，
+// 并将其当前权重减去总权重
+func selectWeightedRoundRobin(c *Context, d *Director, backends []string) uint32 {
+	weights, total := d.getEffectiveWeights(backends)
+	if len(weights) == 0 {
+		return 0
+	}
+	d.wrrLock.Lock()
+	defer d.wrrLock.Unlock()
+	if d.currentWeights == nil {
+		d.currentWeights = make(map[string]float64)
+	}
+	index := 0
+	max := math.Inf(-1)
+	for i, backend := range backends {
+		current := d.currentWeights[backend] + weights[i]
+		d.currentWeights[backend] = current
+		if current > max {
+			max = current
+			index = i
+		}
+	}
+	d.currentWeights[backends[index]] -= total
+	return uint32(index)
+}
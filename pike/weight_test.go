@@ -0,0 +1,102 @@
+package pike
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBackend(t *testing.T) {
+	backend, weight := ParseBackend("http://127.0.0.1:3000  weight=3")
+	if backend != "http://127.0.0.1:3000" || weight != 3 {
+		t.Fatalf("parse backend with weight fail")
+	}
+	backend, weight = ParseBackend("http://127.0.0.1:3000 weight=a")
+	if backend != "http://127.0.0.1:3000" || weight != 1 {
+		t.Fatalf("parse backend with invalid weight fail")
+	}
+
+	d := &Director{
+		Backends: []string{
+			"http://127.0.0.1:3000 weight=3",
+			"http://127.0.0.1:3001",
+		},
+	}
+	d.ParseBackends()
+	if strings.Join(d.Backends, ",") != "http://127.0.0.1:3000,http://127.0.0.1:3001" {
+		t.Fatalf("parse backends fail")
+	}
+	if d.GetWeight("http://127.0.0.1:3000") != 3 || d.GetWeight("http://127.0.0.1:3001") != 1 {
+		t.Fatalf("get weight fail")
+	}
+}
+
+func TestWeightedSelect(t *testing.T) {
+	newDirector := func(policy string) *Director {
+		d := &Director{
+			Policy: policy,
+			Backends: []string{
+				"a weight=3",
+				"b",
+			},
+		}
+		d.Prepare()
+		d.AddAvailableBackend("a")
+		d.AddAvailableBackend("b")
+		return d
+	}
+	c := NewContext(httptest.NewRequest(http.MethodGet, "/", nil))
+	count := func(d *Director, times int) map[string]int {
+		result := make(map[string]int)
+		for i := 0; i < times; i++ {
+			result[d.Select(c)]++
+		}
+		return result
+	}
+
+	t.Run("roundRobin", func(t *testing.T) {
+		result := count(newDirector(roundRobin), 400)
+		if result["a"] != 300 || result["b"] != 100 {
+			t.Fatalf("weighted round robin fail, %v", result)
+		}
+	})
+
+	t.Run("random", func(t *testing.T) {
+		result := count(newDirector(random), 4000)
+		if result["a"] < 2700 || result["a"] > 3300 {
+			t.Fatalf("weighted random fail, %v", result)
+		}
+	})
+
+	t.Run("smooth weighted round robin", func(t *testing.T) {
+		d := newDirector(weightedRoundRobin)
+		list := make([]string, 8)
+		for i := range list {
+			list[i] = d.Select(c)
+		}
+		// 平滑加权轮询不会连续选择同一backend过多次
+		if strings.Join(list, "") != "aabaaaba" {
+			t.Fatalf("smooth weighted round robin fail, %v", list)
+		}
+	})
+
+	t.Run("slow start", func(t *testing.T) {
+		d := newDirector(weightedRoundRobin)
+		d.SlowStart = time.Hour
+		d.RemoveAvailableBackend("b")
+		d.AddAvailableBackend("b")
+		// a也在slow start期间，因此设置为已完成
+		d.availableAt["a"] = time.Now().Add(-time.Hour)
+		result := count(d, 100)
+		if result["b"] > 2 {
+			t.Fatalf("the backend in slow start should get less request, %v", result)
+		}
+		d.availableAt["b"] = time.Now().Add(-time.Hour)
+		result = count(d, 400)
+		if result["a"] != 300 || result["b"] != 100 {
+			t.Fatalf("the backend should use full weight after slow start, %v", result)
+		}
+	})
+}
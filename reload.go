@@ -0,0 +1,350 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/vicanso/pike/cache"
+	"github.com/vicanso/pike/config"
+	"github.com/vicanso/pike/controller"
+	"github.com/vicanso/pike/httplog"
+	"github.com/vicanso/pike/middleware"
+	"github.com/vicanso/pike/pike"
+)
+
+const (
+	// 重新加载配置后，等待正在处理的请求完成再关闭原有的日志
+	closeLogWriterDelay = 30 * time.Second
+)
+
+type (
+	// pikeApp 根据配置生成的director与中间件，重新加载配置时整体替换，
+	// 缓存（cache.Client）与正在处理的请求不受影响
+	pikeApp struct {
+		sync.Mutex
+		configFile   string
+		client       *cache.Client
+		p            *pike.Pike
+		disabledPing *int32
+		conf         *config.Config
+		directors    pike.Directors
+		logWriter    httplog.Writer
+	}
+)
+
+// createDirectors 根据配置生成director列表
+func createDirectors(dc *config.Config) (pike.Directors, error) {
+	directors := make(pike.Directors, 0)
+	for _, item := range dc.Directors {
+		// 校验director的配置（如通过admin修改的配置）
+		err := item.Validate()
+		if err != nil {
+			return nil, errors.New(item.Name + " " + err.Error())
+		}
+		policy := item.Policy
+		err = pike.AddPolicySelectFunc(policy)
+		if err != nil {
+			return nil, errors.New(item.Name + " " + err.Error())
+		}
+		d := &pike.Director{
+			Name:          item.Name,
+			Policy:        policy,
+			Ping:          item.Ping,
+			Backends:      item.Backends,
+			Hosts:         item.Hosts,
+			Prefixs:       item.Prefixs,
+			Rewrites:      item.Rewrites,
+			RequestHeader: item.RequestHeader,
+			Header:        item.Header,
+			SlowStart:     time.Duration(item.SlowStart),
+			TargetURLMap:  make(map[string]*url.URL),
+		}
+		if item.Retry != nil {
+			d.Retry = &pike.Retry{
+				Attempts: item.Retry.Attempts,
+				Status:   item.Retry.Status,
+				Error:    item.Retry.Error,
+				Timeout:  time.Duration(item.Retry.Timeout),
+			}
+		}
+		if item.CircuitBreaker != nil {
+			d.CircuitBreaker = &pike.CircuitBreaker{
+				Failures: item.CircuitBreaker.Failures,
+				CoolDown: time.Duration(item.CircuitBreaker.CoolDown),
+				Status:   item.CircuitBreaker.Status,
+			}
+		}
+		if item.HealthCheck != nil {
+			d.HealthCheckConfig = &pike.HealthCheckConfig{
+				Interval: time.Duration(item.HealthCheck.Interval),
+				Timeout:  time.Duration(item.HealthCheck.Timeout),
+				Rise:     item.HealthCheck.Rise,
+				Fall:     item.HealthCheck.Fall,
+				Status:   item.HealthCheck.Status,
+				Body:     item.HealthCheck.Body,
+				Host:     item.HealthCheck.Host,
+				TCP:      item.HealthCheck.TCP,
+			}
+		}
+		if item.Sticky != nil {
+			d.StickyConfig = &pike.StickyConfig{
+				Cookie: item.Sticky.Cookie,
+				Secret: item.Sticky.Secret,
+				MaxAge: time.Duration(item.Sticky.MaxAge),
+				Path:   item.Sticky.Path,
+			}
+		}
+		if item.Discovery != nil {
+			if !pike.IsDiscoverySupported(item.Discovery.Type) {
+				return nil, errors.New(item.Name + " " + pike.ErrDiscoveryNotSupport.Error())
+			}
+			d.Discovery = &pike.DiscoveryConfig{
+				Type:     item.Discovery.Type,
+				File:     item.Discovery.File,
+				Host:     item.Discovery.Host,
+				SRV:      item.Discovery.SRV,
+				Scheme:   item.Discovery.Scheme,
+				Port:     item.Discovery.Port,
+				Interval: time.Duration(item.Discovery.Interval),
+			}
+		}
+		d.Prepare()
+		for _, backend := range d.Backends {
+			_, err := d.GetTargetURL(&backend)
+			if err != nil {
+				return nil, errors.New(item.Name + " " + backend + " " + err.Error())
+			}
+		}
+		d.SetTransport(
+			&http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   time.Duration(dc.ConnectTimeout),
+					KeepAlive: 30 * time.Second,
+					DualStack: true,
+				}).DialContext,
+				MaxIdleConns:          maxIdleConns,
+				MaxIdleConnsPerHost:   maxIdleConns,
+				IdleConnTimeout:       10 * time.Second,
+				TLSHandshakeTimeout:   10 * time.Second,
+				ExpectContinueTimeout: 1 * time.Second,
+			})
+		directors = append(directors, d)
+	}
+	sort.Sort(directors)
+	return directors, nil
+}
+
+// createMiddlewares 根据配置生成中间件列表
+func (app *pikeApp) createMiddlewares(dc *config.Config, directors pike.Directors, logWriter httplog.Writer) []pike.Middleware {
+	p := app.p
+	client := app.client
+	mids := make([]pike.Middleware, 0)
+	// ping health check
+	mids = append(mids, middleware.Ping(middleware.PingConfig{
+		DisabledPing: app.disabledPing,
+		URL:          "/ping",
+	}))
+
+	// admin管理后台
+	adminConfig := controller.AdminConfig{
+		Prefix:       dc.AdminPath,
+		Token:        dc.AdminToken,
+		Client:       client,
+		Directors:    directors,
+		DisabledPing: app.disabledPing,
+		Reload:       app.reload,
+
+		UpdateDirectors: app.updateDirectors,
+	}
+	mids = append(mids, controller.AdminHandler(adminConfig))
+
+	// 配置logger中间件
+	if logWriter != nil {
+		mids = append(mids, middleware.Logger(middleware.LoggerConfig{
+			LogFormat: dc.LogFormat,
+			Writer:    logWriter,
+		}))
+	}
+
+	mids = append(mids, middleware.Recover(middleware.DefaultRecoverConfig))
+
+	// 初始化中间件的参数
+	initConfig := middleware.InitializationConfig{
+		Header:        dc.Header,
+		RequestHeader: dc.RequestHeader,
+		Concurrency:   dc.Concurrency,
+	}
+	mids = append(mids, middleware.Initialization(initConfig))
+
+	// upgrade请求（websocket等）直接与backend双向转发，不经过缓存流程
+	// （在初始化之后，并发限制与全局的请求头对upgrade请求也生效）
+	mids = append(mids, middleware.Upgrade(middleware.UpgradeConfig{
+		Rewrites:    dc.Rewrites,
+		Timeout:     time.Duration(dc.ConnectTimeout),
+		IdleTimeout: time.Duration(dc.UpgradeIdleTimeout),
+	}, directors))
+
+	// 生成请求唯一标识与状态中间件
+	mids = append(mids, middleware.Identifier(middleware.IdentifierConfig{
+		Format:      dc.Identity,
+		WaitTimeout: time.Duration(dc.WaitTimeout),
+	}, client))
+
+	// 获取director的中间件
+	mids = append(mids, middleware.DirectorPicker(middleware.DirectorPickerConfig{}, directors))
+
+	// 缓存读取中间件
+	// 使用过期缓存时（stale-while-revalidate）通过pike在后台更新缓存
+	mids = append(mids, middleware.CacheFetcher(middleware.CacheFetcherConfig{
+		Handler: p,
+	}, client))
+
+	// 代理转发中间件
+	proxyConfig := middleware.ProxyConfig{
+		ETag:     dc.ETag,
+		Rewrites: dc.Rewrites,
+		Timeout:  time.Duration(dc.ConnectTimeout),
+
+		StreamPass:      dc.StreamPass,
+		StreamThreshold: dc.StreamThreshold,
+		StreamCache:     dc.StreamCache,
+	}
+	mids = append(mids, middleware.Proxy(proxyConfig, client))
+
+	// http响应头设置中间件
+	headerSetterConfig := middleware.HeaderSetterConfig{}
+	mids = append(mids, middleware.HeaderSetter(headerSetterConfig))
+
+	// 判断客户端缓存请求是否fresh的中间件
+	freshCheckerConfig := middleware.FreshCheckerConfig{}
+	mids = append(mids, middleware.FreshChecker(freshCheckerConfig))
+
+	// 响应数据处理中间件
+	dispatcherConfig := middleware.DispatcherConfig{
+		CompressTypes:     dc.TextTypes,
+		CompressMinLength: dc.CompressMinLength,
+		CompressLevel:     dc.CompressLevel,
+	}
+	mids = append(mids, middleware.Dispatcher(dispatcherConfig, client))
+	return mids
+}
+
+// apply 使用配置生成director与中间件，并替换原有的
+func (app *pikeApp) apply(dc *config.Config) error {
+	directors, err := createDirectors(dc)
+	if err != nil {
+		return err
+	}
+	// 日志配置未变化则使用原有的writer
+	logWriter := app.logWriter
+	var prevLogWriter httplog.Writer
+	prev := app.conf
+	if prev == nil || prev.AccessLog != dc.AccessLog || prev.LogType != dc.LogType {
+		prevLogWriter = logWriter
+		logWriter = nil
+		if len(dc.AccessLog) != 0 {
+			logWriter = getLogger(dc)
+		}
+	}
+	// 继承原有backend的可用状态，避免等待health check期间无可用的backend
+	for _, d := range directors {
+		for _, prevDirector := range app.directors {
+			if prevDirector.Name == d.Name {
+				d.Inherit(prevDirector)
+				break
+			}
+		}
+		if d.Discovery != nil {
+			// discovery（如dns解析）可能较慢，不阻塞配置的加载
+			go d.StartDiscovery()
+		}
+		// 定时检测director是否可用（未配置检测间隔则使用默认值）
+		go d.StartHealthCheck(defaultHealthCheckInterval)
+	}
+	app.p.SetMiddleware(app.createMiddlewares(dc, directors, logWriter)...)
+
+	for _, d := range app.directors {
+		d.StopHealthCheck()
+	}
+	if prevLogWriter != nil {
+		time.AfterFunc(closeLogWriterDelay, func() {
+			prevLogWriter.Close()
+		})
+	}
+	app.conf = dc
+	app.directors = directors
+	app.logWriter = logWriter
+	return nil
+}
+
+// reload 重新加载配置文件
+func (app *pikeApp) reload() error {
+	app.Lock()
+	defer app.Unlock()
+	dc, err := config.InitFromFile(app.configFile)
+	if err != nil {
+		return err
+	}
+	err = dc.Validate()
+	if err != nil {
+		return err
+	}
+	prev := app.conf
+	if prev.Listen != dc.Listen ||
+		prev.DB != dc.DB ||
+		prev.Storage != dc.Storage ||
+		prev.MaxCacheSize != dc.MaxCacheSize ||
+		prev.MaxCacheEntries != dc.MaxCacheEntries ||
+		prev.EvictionPolicy != dc.EvictionPolicy ||
+		prev.TagHeader != dc.TagHeader ||
+		prev.ExpiredClearInterval != dc.ExpiredClearInterval ||
+		prev.EnableServerTiming != dc.EnableServerTiming {
+		log.Warn("the listen, cache and server timing config can not be reloaded, restart is required")
+	}
+	err = app.apply(dc)
+	if err != nil {
+		return err
+	}
+	log.Infof("reload the config: %s", app.configFile)
+	return nil
+}
+
+// updateDirectors 修改director的配置并生效（校验失败则不修改），persist为true则保存至配置文件
+func (app *pikeApp) updateDirectors(update func([]*config.Director) ([]*config.Director, error), persist bool) error {
+	app.Lock()
+	defer app.Unlock()
+	directors := make([]*config.Director, len(app.conf.Directors))
+	for i, item := range app.conf.Directors {
+		directors[i] = item.Clone()
+	}
+	directors, err := update(directors)
+	if err != nil {
+		return err
+	}
+	dc := *app.conf
+	dc.Directors = directors
+	err = app.apply(&dc)
+	if err != nil {
+		return err
+	}
+	if persist {
+		return dc.WriteToFile(app.configFile)
+	}
+	return nil
+}
+
+// close 关闭日志
+func (app *pikeApp) close() {
+	app.Lock()
+	defer app.Unlock()
+	if app.logWriter != nil {
+		app.logWriter.Close()
+	}
+}
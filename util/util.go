@@ -3,6 +3,7 @@ package util
 import (
 	"bytes"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -32,6 +33,11 @@ const (
 	httpsProto = "HTTPS"
 )
 
+var (
+	// ErrRewriteInvalid rewrite的配置格式错误（需要为from:to）
+	ErrRewriteInvalid = errors.New("rewrite should be from:to")
+)
+
 func noop() {}
 
 // Gzip 对数据压缩
@@ -120,18 +126,28 @@ func GetHumanReadableSize(size int) string {
 	return s + "MB"
 }
 
-// GetRewriteRegexp 获取rewrite的正式匹配表
+// ParseRewrite 解析rewrite的配置（from:to），返回from的正则与替换的目标
+func ParseRewrite(rewrite string) (*regexp.Regexp, string, error) {
+	arr := strings.Split(rewrite, ":")
+	if len(arr) != 2 {
+		return nil, "", ErrRewriteInvalid
+	}
+	reg, err := regexp.Compile(strings.Replace(arr[0], "*", "(\\S*)", -1))
+	if err != nil {
+		return nil, "", err
+	}
+	return reg, arr[1], nil
+}
+
+// GetRewriteRegexp 获取rewrite的正式匹配表（忽略配置错误的rewrite）
 func GetRewriteRegexp(rewrites []string) map[*regexp.Regexp]string {
 	rewriteRegexp := make(map[*regexp.Regexp]string)
 	for _, value := range rewrites {
-		arr := strings.Split(value, ":")
-		if len(arr) != 2 {
+		reg, target, err := ParseRewrite(value)
+		if err != nil {
 			continue
 		}
-		k := arr[0]
-		v := arr[1]
-		k = strings.Replace(k, "*", "(\\S*)", -1)
-		rewriteRegexp[regexp.MustCompile(k)] = v
+		rewriteRegexp[reg] = target
 	}
 	return rewriteRegexp
 }
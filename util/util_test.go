@@ -101,6 +101,19 @@ func TestGetRewriteRegexp(t *testing.T) {
 	}
 }
 
+func TestParseRewrite(t *testing.T) {
+	reg, target, err := ParseRewrite("/api/*:/$1")
+	if err != nil || target != "/$1" || reg.ReplaceAllString("/api/users/me", target) != "/users/me" {
+		t.Fatalf("parse rewrite fail, %v", err)
+	}
+	if _, _, err = ParseRewrite("/api"); err != ErrRewriteInvalid {
+		t.Fatalf("rewrite without target should return error")
+	}
+	if _, _, err = ParseRewrite("/api/(*:/$1"); err == nil {
+		t.Fatalf("invalid rewrite regexp should return error")
+	}
+}
+
 func TestGetIdentity(t *testing.T) {
 	req := &http.Request{
 		Method:     "GET",